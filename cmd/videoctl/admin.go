@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"upload/internal/collection"
+	"upload/internal/config"
+	"upload/internal/fsck"
+	"upload/internal/fsutil"
+	"upload/internal/meta"
+	"upload/internal/processor"
+)
+
+func openStore(cfg config.Config) *meta.JSONStore {
+	return meta.NewJSONStore(fsutil.MetadataDir(cfg.StorageDir))
+}
+
+func getVideo(store meta.Store, vid string) (meta.Metadata, error) {
+	m, err := store.Get(vid)
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, fmt.Errorf("video %s not found", vid)
+	}
+	return m, err
+}
+
+func runList(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("list", flag.ExitOnError)
+	status := flags.String("status", "", "only show videos with this status")
+	tenant := flags.String("tenant", "", "only show videos of this tenant")
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	flags.Parse(args)
+
+	videos, err := openStore(cfg).List()
+	if err != nil {
+		return err
+	}
+	filtered := videos[:0]
+	for _, v := range videos {
+		if (*status == "" || v.Status == *status) && (*tenant == "" || v.Tenant == *tenant) {
+			filtered = append(filtered, v)
+		}
+	}
+
+	if *asJSON {
+		return printJSON(filtered)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tSIZE\tDURATION\tHEIGHT\tCREATED\tFILENAME")
+	for _, v := range filtered {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%.1fs\t%d\t%s\t%s\n",
+			v.ID, v.Status, v.SizeBytes, v.DurationSec, v.Height, v.CreatedAt.Format(time.DateTime), v.OriginalFilename)
+	}
+	return w.Flush()
+}
+
+func runShow(cfg config.Config, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: videoctl show <id>")
+	}
+	m, err := getVideo(openStore(cfg), args[0])
+	if err != nil {
+		return err
+	}
+	return printJSON(m)
+}
+
+func runReprocess(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("reprocess", flag.ExitOnError)
+	noProcess := flags.Bool("no-process", false, "only reset to queued; the server picks it up on start")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("usage: videoctl reprocess [-no-process] <id>...")
+	}
+
+	store := openStore(cfg)
+	var queue *processor.Queue
+	if !*noProcess {
+		queue = processor.NewQueue(processor.New(cfg, store), cfg.Workers)
+	}
+
+	// Keep going past bad ids so the videos already queued are still
+	// processed before we exit.
+	var queued []string
+	var errs []error
+	for _, vid := range flags.Args() {
+		m, err := getVideo(store, vid)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if m.Status == "processing" || m.Status == "importing" {
+			errs = append(errs, fmt.Errorf("video %s is %s; cancel it first", vid, m.Status))
+			continue
+		}
+
+		root := fsutil.TenantRoot(cfg.StorageDir, m.Tenant)
+		os.RemoveAll(fsutil.OutputsDir(root, vid))
+		os.RemoveAll(fsutil.ThumbnailsDir(root, vid))
+		os.RemoveAll(fsutil.KeysDir(root, vid))
+		m.Status = "queued"
+		m.ErrorMessage = ""
+		m.Variants = []meta.Variant{}
+		// their files went with the thumbnails directory
+		m.Thumbnails = nil
+		m.Sprites = nil
+		m.Preview = nil
+		if err := store.Update(m); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("%s queued\n", vid)
+		queued = append(queued, vid)
+		if queue != nil {
+			queue.Enqueue(vid)
+		}
+	}
+
+	if queue != nil {
+		queue.Close()
+		for _, vid := range queued {
+			if m, err := store.Get(vid); err == nil {
+				fmt.Printf("%s %s %s\n", vid, m.Status, m.ErrorMessage)
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func runCancel(cfg config.Config, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: videoctl cancel <id>...")
+	}
+
+	// like reprocess, one bad id doesn't keep the others running
+	store := openStore(cfg)
+	var errs []error
+	for _, vid := range args {
+		m, err := getVideo(store, vid)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		switch m.Status {
+		case "queued", "processing", "importing":
+		default:
+			errs = append(errs, fmt.Errorf("video %s is %s, nothing to cancel", vid, m.Status))
+			continue
+		}
+
+		m.Status = "canceled"
+		m.ErrorMessage = "canceled by operator"
+		if err := store.Update(m); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		fmt.Printf("%s canceled\n", vid)
+	}
+
+	return errors.Join(errs...)
+}
+
+func runDelete(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("delete", flag.ExitOnError)
+	force := flags.Bool("force", false, "delete even while the video is being processed")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("usage: videoctl delete [-force] <id>...")
+	}
+
+	store := openStore(cfg)
+	for _, vid := range flags.Args() {
+		m, err := getVideo(store, vid)
+		if err != nil {
+			return err
+		}
+		if !*force && (m.Status == "processing" || m.Status == "importing") {
+			return fmt.Errorf("video %s is %s; cancel it first or use -force", vid, m.Status)
+		}
+
+		root := fsutil.TenantRoot(cfg.StorageDir, m.Tenant)
+		for _, dir := range []string{
+			fsutil.OriginalsDir(root, vid),
+			fsutil.OutputsDir(root, vid),
+			fsutil.ThumbnailsDir(root, vid),
+			fsutil.KeysDir(root, vid),
+		} {
+			if err := os.RemoveAll(dir); err != nil {
+				return err
+			}
+		}
+		if err := store.Delete(vid); err != nil {
+			return err
+		}
+		if err := collection.NewStore(fsutil.CollectionsDir(cfg.StorageDir)).RemoveVideo(vid); err != nil {
+			return err
+		}
+		frames, _ := filepath.Glob(filepath.Join(fsutil.FrameCacheDir(cfg.StorageDir), vid+"_*"))
+		for _, p := range frames {
+			os.Remove(p)
+		}
+		fmt.Printf("%s deleted\n", vid)
+	}
+
+	return nil
+}
+
+func runVerify(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("verify", flag.ExitOnError)
+	checksum := flags.Bool("checksum", false, "also re-hash originals against checksum_sha256")
+	asJSON := flags.Bool("json", false, "print the report as JSON")
+	flags.Parse(args)
+
+	checker := fsck.NewChecker(cfg.StorageDir, openStore(cfg))
+	report, err := checker.Scan(fsck.Options{MinAge: cfg.GCMinAge, Checksum: *checksum, IDs: flags.Args()})
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		if err := printJSON(report); err != nil {
+			return err
+		}
+	} else {
+		for _, issue := range report.Issues {
+			fmt.Println(issue)
+		}
+		fmt.Printf("verified %d videos, %d issues\n", report.Videos, len(report.Issues))
+	}
+	if len(report.Issues) > 0 {
+		return fmt.Errorf("%d issues found", len(report.Issues))
+	}
+	return nil
+}
+
+func runGC(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("gc", flag.ExitOnError)
+	dryRun := flags.Bool("dry-run", false, "only print what would be done")
+	minAge := flags.Duration("min-age", cfg.GCMinAge, "leave temp files and orphans younger than this alone")
+	deleteMissing := flags.Bool("delete-missing", false, "delete videos whose original is gone instead of marking them failed")
+	flags.Parse(args)
+
+	checker := fsck.NewChecker(cfg.StorageDir, openStore(cfg))
+	report, err := checker.Scan(fsck.Options{MinAge: *minAge})
+	if err != nil {
+		return err
+	}
+	for _, issue := range report.Issues {
+		fmt.Println(issue)
+	}
+
+	result, err := checker.Repair(report, fsck.RepairOptions{DryRun: *dryRun, DeleteMissing: *deleteMissing})
+	for _, action := range result.Actions {
+		if *dryRun {
+			fmt.Println("would " + action)
+		} else {
+			fmt.Println(action)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if len(result.Requeued) > 0 && !*dryRun {
+		fmt.Printf("%d videos requeued; run `videoctl reprocess` or restart the server to process them\n", len(result.Requeued))
+	}
+
+	return nil
+}
+
+func printJSON(v any) error {
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"path/filepath"
+	"time"
+
+	"upload/internal/config"
+	"upload/internal/fsutil"
+	"upload/internal/id"
+	"upload/internal/ingest"
+	"upload/internal/meta"
+	"upload/internal/processor"
+)
+
+func runIngest(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("ingest", flag.ExitOnError)
+	link := flags.Bool("link", false, "hard-link originals instead of copying (falls back to copy across filesystems)")
+	workers := flags.Int("workers", cfg.Workers, "number of videos processed concurrently")
+	manifestPath := flags.String("manifest", "", "manifest file (default <dir>/.videoctl-manifest.json)")
+	noProcess := flags.Bool("no-process", false, "only register videos as queued; the server picks them up on start")
+	tenant := flags.String("tenant", "", "tenant the videos belong to (default: the default tenant)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: videoctl ingest [flags] <dir>")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		return errors.New("a directory is required")
+	}
+	if *tenant != "" && !fsutil.ValidTenant(*tenant) {
+		return fmt.Errorf("invalid tenant %q", *tenant)
+	}
+
+	root, err := filepath.Abs(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if *manifestPath == "" {
+		*manifestPath = filepath.Join(root, ".videoctl-manifest.json")
+	}
+
+	if err := fsutil.EnsureLayout(cfg.StorageDir); err != nil {
+		return fmt.Errorf("create storage layout: %w", err)
+	}
+	manifest, err := ingest.LoadManifest(*manifestPath, root)
+	if err != nil {
+		return fmt.Errorf("load manifest: %w", err)
+	}
+	files, err := ingest.Walk(root)
+	if err != nil {
+		return fmt.Errorf("walk %s: %w", root, err)
+	}
+
+	store := meta.NewJSONStore(fsutil.MetadataDir(cfg.StorageDir))
+	var queue *processor.Queue
+	if !*noProcess {
+		queue = processor.NewQueue(processor.New(cfg, store), *workers)
+	}
+
+	var imported, skipped, failed int
+	var queued []string
+	for _, rel := range files {
+		if manifest.Done(rel) {
+			skipped++
+			continue
+		}
+
+		entry := ingest.ManifestEntry{ID: id.New(), Status: ingest.ManifestImported, ImportedAt: time.Now()}
+		if err := ingestFile(cfg, store, filepath.Join(root, rel), entry.ID, *tenant, *link, &entry); err != nil {
+			log.Printf("%s: %v", rel, err)
+			entry = ingest.ManifestEntry{Status: ingest.ManifestFailed, Error: err.Error()}
+			failed++
+		} else {
+			log.Printf("%s -> %s", rel, entry.ID)
+			imported++
+			queued = append(queued, rel)
+			if queue != nil {
+				queue.Enqueue(entry.ID)
+			}
+		}
+		if err := manifest.Set(rel, entry); err != nil {
+			return fmt.Errorf("write manifest: %w", err)
+		}
+	}
+
+	if queue != nil {
+		queue.Close()
+		// record how processing went for this run's imports
+		for _, rel := range queued {
+			entry, _ := manifest.Entry(rel)
+			if m, err := store.Get(entry.ID); err == nil {
+				entry.VideoStatus = m.Status
+				manifest.Set(rel, entry)
+			}
+		}
+	}
+
+	fmt.Printf("imported %d, skipped %d, failed %d (manifest: %s)\n", imported, skipped, failed, *manifestPath)
+	if failed > 0 {
+		return fmt.Errorf("%d files failed", failed)
+	}
+
+	return nil
+}
+
+func ingestFile(cfg config.Config, store meta.Store, src, vid, tenant string, link bool, entry *ingest.ManifestEntry) error {
+	filename := filepath.Base(src)
+	root := fsutil.TenantRoot(cfg.StorageDir, tenant)
+	res, err := ingest.Place(src, fsutil.OriginalPath(root, vid, filename), link)
+	if err != nil {
+		return fmt.Errorf("place original: %w", err)
+	}
+
+	m := meta.Metadata{
+		ID:               vid,
+		OriginalFilename: filename,
+		MIME:             ingest.DetectMIME("", filepath.Ext(filename)),
+		SizeBytes:        res.SizeBytes,
+		ChecksumSHA256:   res.ChecksumSHA256,
+		Status:           "queued",
+		Tenant:           tenant,
+		StorageBase:      root,
+		Variants:         []meta.Variant{},
+	}
+	if err := store.Create(m); err != nil {
+		return fmt.Errorf("create metadata: %w", err)
+	}
+	entry.SizeBytes = res.SizeBytes
+
+	return nil
+}
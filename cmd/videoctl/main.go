@@ -0,0 +1,75 @@
+// Command videoctl operates on the upload service's storage directly, without
+// the HTTP server running.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"upload/internal/config"
+	"upload/internal/transcoder"
+)
+
+const usage = `usage: videoctl <command> [flags] [args]
+
+commands:
+  ingest     import every video under a directory
+  list       list videos
+  show       print a video's metadata
+  reprocess  discard outputs and process videos again
+  cancel     cancel queued or running jobs
+  delete     delete videos and all their files
+  verify     check originals and outputs against metadata
+  gc         remove orphaned directories and stale temp files
+  sign       print signed, expiring playback URLs
+  usage      report storage and encoding usage per tenant
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	if err := transcoder.CheckConfig(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "videoctl: %v\n", err)
+		os.Exit(1)
+	}
+	args := os.Args[2:]
+
+	var err error
+	switch os.Args[1] {
+	case "ingest":
+		err = runIngest(cfg, args)
+	case "list":
+		err = runList(cfg, args)
+	case "show":
+		err = runShow(cfg, args)
+	case "reprocess":
+		err = runReprocess(cfg, args)
+	case "cancel":
+		err = runCancel(cfg, args)
+	case "delete":
+		err = runDelete(cfg, args)
+	case "verify":
+		err = runVerify(cfg, args)
+	case "gc":
+		err = runGC(cfg, args)
+	case "sign":
+		err = runSign(cfg, args)
+	case "usage":
+		err = runUsage(cfg, args)
+	case "help", "-h", "--help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "videoctl %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"upload/internal/config"
+	"upload/internal/signing"
+)
+
+// runSign prints signed URLs for a video's master playlist, or for the
+// given paths (e.g. /thumbnails/<id>/poster.jpg, /videos/<id>/download/720).
+func runSign(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("sign", flag.ExitOnError)
+	ttl := flags.Duration("ttl", cfg.SigningTTL, "how long the URL stays valid")
+	ip := flags.String("ip", "", "only accept the URL from this client IP")
+	base := flags.String("base", "", "prepend this origin, e.g. https://media.example.com")
+	flags.Parse(args)
+	if flags.NArg() == 0 {
+		return errors.New("usage: videoctl sign [-ttl 1h] [-ip addr] [-base url] <id | /path>...")
+	}
+	if cfg.SigningSecret == "" {
+		return errors.New("URL_SIGNING_SECRET is not set")
+	}
+
+	signer := signing.NewSigner(cfg.SigningSecret)
+	grant := signing.Grant{Expires: time.Now().Add(*ttl), IP: *ip}
+	for _, arg := range flags.Args() {
+		p := arg
+		if !strings.HasPrefix(p, "/") {
+			p = "/videos/" + arg + "/master.m3u8"
+		}
+		fmt.Println(strings.TrimSuffix(*base, "/") + signer.Sign(p, grant))
+	}
+
+	return nil
+}
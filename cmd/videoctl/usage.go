@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"upload/internal/config"
+	"upload/internal/quota"
+)
+
+func runUsage(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("usage", flag.ExitOnError)
+	asJSON := flags.Bool("json", false, "print JSON instead of a table")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "usage: videoctl usage [-json] [tenant...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	quotas, err := quota.NewManager(cfg, openStore(cfg))
+	if err != nil {
+		return err
+	}
+	tenants := flags.Args()
+	if len(tenants) == 0 {
+		if tenants, err = quotas.Tenants(); err != nil {
+			return err
+		}
+	}
+
+	usages := make([]quota.Usage, 0, len(tenants))
+	for _, tenant := range tenants {
+		usage, err := quotas.Usage(tenant)
+		if err != nil {
+			return err
+		}
+		usages = append(usages, usage)
+	}
+	if *asJSON {
+		return printJSON(usages)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TENANT\tVIDEOS\tSTORAGE\tSTORAGE QUOTA\tMONTH\tENCODED\tENCODING QUOTA")
+	for _, u := range usages {
+		name := u.Tenant
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%.1f min\t%s\n",
+			name, u.Videos, megabytes(u.StorageBytes), limit(megabytes(u.StorageQuotaBytes), u.StorageQuotaBytes == 0),
+			u.Month, u.EncodingMinutes, limit(fmt.Sprintf("%g min", u.EncodingQuotaMinutes), u.EncodingQuotaMinutes == 0))
+	}
+	return w.Flush()
+}
+
+func megabytes(n int64) string {
+	return fmt.Sprintf("%.1f MB", float64(n)/(1024*1024))
+}
+
+// limit shows "-" for unlimited quotas.
+func limit(s string, unlimited bool) string {
+	if unlimited {
+		return "-"
+	}
+	return s
+}
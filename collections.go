@@ -0,0 +1,330 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"upload/internal/collection"
+	"upload/internal/config"
+	"upload/internal/fsutil"
+	"upload/internal/httpapi"
+	"upload/internal/id"
+	"upload/internal/meta"
+	appmiddleware "upload/internal/middleware"
+	"upload/internal/signing"
+)
+
+// collectionResponse is col as the API returns it, with signed URLs when
+// URL_SIGNING_SECRET is set.
+func (server *Server) collectionResponse(c echo.Context, col collection.Collection, withVideos bool) httpapi.CollectionResponse {
+	grant := signing.Grant{Expires: time.Now().Add(server.config.SigningTTL)}
+	sign := func(p string) string {
+		if server.signer == nil {
+			return p
+		}
+		return server.signer.Sign(p, grant)
+	}
+	// only owners get the playlist that includes their private videos
+	playlist := "/collections/" + col.ID + "/master.m3u8"
+	if appmiddleware.CurrentPrincipal(c) != nil && collectionOwned(c, col) {
+		playlist = "/collections/" + col.ID + "/owner/master.m3u8"
+	}
+	resp := httpapi.CollectionResponse{
+		Collection:  col,
+		PlaylistURL: sign(playlist),
+	}
+	if col.Cover != "" {
+		resp.CoverURL = sign("/" + col.Cover)
+	}
+	if withVideos {
+		resp.Videos = collectionVideos(c, server.store, col)
+	}
+	return resp
+}
+
+// loadCollection returns the :id collection, or answers the request and
+// returns an empty one when the caller may not see it (or, with owner, may
+// not change it).
+func (server *Server) loadCollection(c echo.Context, owner bool) (collection.Collection, error) {
+	cid := c.Param("id")
+	if !id.Valid(cid) {
+		return collection.Collection{}, c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	col, err := server.collections.Get(cid)
+	if err != nil || !collectionVisible(c, col) {
+		return collection.Collection{}, c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if owner && !collectionOwned(c, col) {
+		return collection.Collection{}, c.JSON(http.StatusForbidden, map[string]string{"error": "only the owner can change this collection"})
+	}
+	return col, nil
+}
+
+func collectionError(c echo.Context, err error) error {
+	if errors.Is(err, collection.ErrInvalid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot save collection"})
+}
+
+func (server *Server) createCollection(c echo.Context) error {
+	var edit collection.Edit
+	if err := decodeJSON(c, &edit); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	col := collection.Collection{ID: id.New(), Tenant: tenantOf(c), Owner: ownerOf(c)}
+	if err := edit.Apply(&col); err != nil {
+		return collectionError(c, err)
+	}
+	if err := checkCollection(c, server.config, server.store, col); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	}
+	if err := server.collections.Create(col); err != nil {
+		return collectionError(c, err)
+	}
+	col, _ = server.collections.Get(col.ID)
+
+	return c.JSON(http.StatusCreated, server.collectionResponse(c, col, true))
+}
+
+func (server *Server) listCollections(c echo.Context) error {
+	all, err := server.collections.List()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list collections"})
+	}
+	listed := []httpapi.CollectionResponse{}
+	for _, col := range all {
+		if collectionOwned(c, col) || (collectionVisible(c, col) && col.Visibility == meta.VisibilityPublic) {
+			listed = append(listed, server.collectionResponse(c, col, false))
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"collections": listed})
+}
+
+func (server *Server) showCollection(c echo.Context) error {
+	col, err := server.loadCollection(c, false)
+	if err != nil || col.ID == "" {
+		return err
+	}
+	return c.JSON(http.StatusOK, server.collectionResponse(c, col, true))
+}
+
+func (server *Server) editCollection(c echo.Context) error {
+	col, err := server.loadCollection(c, true)
+	if err != nil || col.ID == "" {
+		return err
+	}
+	var edit collection.Edit
+	if err := decodeJSON(c, &edit); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	col, err = server.collections.Modify(col.ID, func(col *collection.Collection) error {
+		if err := edit.Apply(col); err != nil {
+			return err
+		}
+		if err := checkCollection(c, server.config, server.store, *col); err != nil {
+			return fmt.Errorf("%w: %v", collection.ErrInvalid, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, server.collectionResponse(c, col, true))
+}
+
+func (server *Server) deleteCollection(c echo.Context) error {
+	col, err := server.loadCollection(c, true)
+	if err != nil || col.ID == "" {
+		return err
+	}
+	if err := server.collections.Delete(col.ID); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot delete collection"})
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (server *Server) addCollectionVideo(c echo.Context) error {
+	col, err := server.loadCollection(c, true)
+	if err != nil || col.ID == "" {
+		return err
+	}
+	var req struct {
+		VideoID  string `json:"video_id"`
+		Position *int   `json:"position"`
+	}
+	if err := decodeJSON(c, &req); err != nil || !id.Valid(req.VideoID) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "video_id is required"})
+	}
+	col, err = server.collections.Modify(col.ID, func(col *collection.Collection) error {
+		position := len(col.VideoIDs)
+		if req.Position != nil {
+			position = min(max(*req.Position, 0), len(col.VideoIDs))
+		}
+		col.VideoIDs = slices.Insert(col.VideoIDs, position, req.VideoID)
+		if err := checkCollection(c, server.config, server.store, *col); err != nil {
+			return fmt.Errorf("%w: %v", collection.ErrInvalid, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, server.collectionResponse(c, col, true))
+}
+
+func (server *Server) removeCollectionVideo(c echo.Context) error {
+	col, err := server.loadCollection(c, true)
+	if err != nil || col.ID == "" {
+		return err
+	}
+	if !slices.Contains(col.VideoIDs, c.Param("vid")) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "video is not in the collection"})
+	}
+	col, err = server.collections.Modify(col.ID, func(col *collection.Collection) error {
+		col.Without(c.Param("vid"))
+		return nil
+	})
+	if err != nil {
+		return collectionError(c, err)
+	}
+	return c.JSON(http.StatusOK, server.collectionResponse(c, col, true))
+}
+
+// servePlaylistData serves a generated collection playlist, signing its URIs
+// when URL_SIGNING_SECRET is set.
+func (server *Server) servePlaylistData(c echo.Context, data string) error {
+	if server.signer != nil {
+		return serveSignedPlaylist(c, server.signer, []byte(data), c.Request().URL.Path)
+	}
+	c.Response().Header().Set("Cache-Control", "no-cache")
+	return c.Blob(http.StatusOK, "application/vnd.apple.mpegurl", []byte(data))
+}
+
+// playableVideos loads the :id collection and its ready videos the request
+// may play: with ownerScope the owner's private ones too.
+func (server *Server) playableVideos(c echo.Context, ownerScope bool) (collection.Collection, []meta.Metadata, error) {
+	col, err := server.loadCollection(c, false)
+	if err != nil || col.ID == "" {
+		return col, nil, err
+	}
+	if appmiddleware.CurrentPrincipal(c) == nil && server.authenticator != nil {
+		return col, collection.Playable(signedCollectionVideos(server.store, col, ownerScope)), nil
+	}
+	if ownerScope && !collectionOwned(c, col) {
+		return collection.Collection{}, nil, c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	return col, collection.Playable(collectionVideos(c, server.store, col)), nil
+}
+
+func (server *Server) collectionMaster(ownerScope bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		col, videos, err := server.playableVideos(c, ownerScope)
+		if err != nil || col.ID == "" {
+			return err
+		}
+		playlist, err := collection.MasterPlaylist(videos)
+		if err != nil {
+			return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+		}
+		return server.servePlaylistData(c, playlist)
+	}
+}
+
+func (server *Server) collectionRendition(ownerScope bool) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		col, videos, err := server.playableVideos(c, ownerScope)
+		if err != nil || col.ID == "" {
+			return err
+		}
+		rendition, ok := strings.CutSuffix(c.Param("rendition"), ".m3u8")
+		if !ok {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+		}
+		playlist, err := collection.MediaPlaylist(videos, rendition, func(m meta.Metadata) string {
+			return fsutil.TenantRoot(server.config.StorageDir, m.Tenant)
+		})
+		if errors.Is(err, collection.ErrNotPlayable) {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": err.Error()})
+		}
+		if err != nil {
+			log.Printf("Collection %s playlist %s: %v", col.ID, rendition, err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot build playlist"})
+		}
+		return server.servePlaylistData(c, playlist)
+	}
+}
+
+// collectionVisible reports whether the caller may see col: its own
+// collections plus unlisted and public ones of its tenant.
+func collectionVisible(c echo.Context, col collection.Collection) bool {
+	principal := appmiddleware.CurrentPrincipal(c)
+	return principal == nil || (principal.InTenant(col.Tenant) && (principal.Owns(col.Owner) || col.Shared()))
+}
+
+// collectionOwned reports whether the caller may change col.
+func collectionOwned(c echo.Context, col collection.Collection) bool {
+	principal := appmiddleware.CurrentPrincipal(c)
+	return principal == nil || (principal.InTenant(col.Tenant) && principal.Owns(col.Owner))
+}
+
+// checkCollection makes sure every video of col is in its tenant and
+// visible to the caller, and that the cover is an existing thumbnail of one
+// of them.
+func checkCollection(c echo.Context, cfg config.Config, store meta.Store, col collection.Collection) error {
+	for _, vid := range col.VideoIDs {
+		m, err := tenantStore(c, store).Get(vid)
+		if !id.Valid(vid) || err != nil || m.Tenant != col.Tenant || !visible(c, m) {
+			return fmt.Errorf("video %s not found", vid)
+		}
+	}
+	if vid, ok := collection.CoverVideo(col.Cover); ok {
+		// the members were checked above; the cover must be one of them
+		m, err := tenantStore(c, store).Get(vid)
+		if err != nil || !slices.Contains(col.VideoIDs, vid) || m.Tenant != col.Tenant || !visible(c, m) {
+			return fmt.Errorf("cover %s does not exist", col.Cover)
+		}
+		if _, err := os.Stat(filepath.Join(fsutil.TenantRoot(cfg.StorageDir, m.Tenant), filepath.FromSlash(col.Cover))); err != nil {
+			return fmt.Errorf("cover %s does not exist", col.Cover)
+		}
+	}
+	return nil
+}
+
+// collectionVideos loads the videos of col the caller may see, in order.
+// Videos deleted since they were added are skipped.
+func collectionVideos(c echo.Context, store meta.Store, col collection.Collection) []meta.Metadata {
+	videos := []meta.Metadata{}
+	for _, vid := range col.VideoIDs {
+		if m, err := tenantStore(c, store).Get(vid); err == nil && visible(c, m) {
+			videos = append(videos, m)
+		}
+	}
+	return videos
+}
+
+// signedCollectionVideos is collectionVideos for signed playlist requests,
+// which carry no caller: the shared videos of col plus, for ownerScope, the
+// private ones of its owner.
+func signedCollectionVideos(store meta.Store, col collection.Collection, ownerScope bool) []meta.Metadata {
+	videos := []meta.Metadata{}
+	for _, vid := range col.VideoIDs {
+		m, err := store.Get(vid)
+		if err != nil || m.Tenant != col.Tenant {
+			continue
+		}
+		if m.Shared() || (ownerScope && m.Owner == col.Owner) {
+			videos = append(videos, m)
+		}
+	}
+	return videos
+}
@@ -0,0 +1,139 @@
+// Package auth authenticates API requests with static API keys or JWT bearer
+// tokens and carries the caller's scopes.
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"upload/internal/config"
+	"upload/internal/fsutil"
+)
+
+const (
+	ScopeUpload = "upload"
+	ScopeRead   = "read"
+	ScopeDelete = "delete"
+	ScopeAdmin  = "admin" // implies every other scope and access to every video
+)
+
+var (
+	ErrNoCredentials = errors.New("missing credentials")
+	ErrInvalidKey    = errors.New("invalid api key")
+)
+
+// Principal is the authenticated caller.
+type Principal struct {
+	ID     string   // "key:<name>" or "jwt:<iss>:<sub>", so the two can't collide
+	Method string   // api_key, jwt
+	Scopes []string // upload, read, delete, admin
+	Tenant string   // "" is the default tenant
+}
+
+// Has reports whether the principal was granted scope. admin grants all.
+func (principal *Principal) Has(scope string) bool {
+	return slices.Contains(principal.Scopes, scope) || slices.Contains(principal.Scopes, ScopeAdmin)
+}
+
+// Global reports whether the principal sees every tenant: an admin that
+// belongs to no tenant.
+func (principal *Principal) Global() bool {
+	return principal.Tenant == "" && principal.Has(ScopeAdmin)
+}
+
+// InTenant reports whether the principal may see videos of tenant.
+func (principal *Principal) InTenant(tenant string) bool {
+	return principal.Global() || principal.Tenant == tenant
+}
+
+// Owns reports whether the principal may see a video owned by owner.
+// Videos without an owner (CLI ingests, uploads made before auth was
+// enabled) are only visible to admins.
+func (principal *Principal) Owns(owner string) bool {
+	return principal.Has(ScopeAdmin) || (owner != "" && owner == principal.ID)
+}
+
+// APIKey is one entry of the API_KEYS_FILE JSON array.
+type APIKey struct {
+	Name   string   `json:"name"` // uploads are owned by "key:<name>"
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+	Tenant string   `json:"tenant,omitempty"`
+}
+
+type Authenticator struct {
+	keys map[[sha256.Size]byte]APIKey
+	jwt  *jwtVerifier
+}
+
+// NewAuthenticator loads the configured credentials. It returns nil, nil when
+// none are configured, i.e. the API is open.
+func NewAuthenticator(cfg config.Config) (*Authenticator, error) {
+	if cfg.APIKeysFile == "" && cfg.JWTSecret == "" && cfg.JWKSFile == "" {
+		return nil, nil
+	}
+
+	authenticator := &Authenticator{keys: map[[sha256.Size]byte]APIKey{}}
+	if cfg.APIKeysFile != "" {
+		data, err := os.ReadFile(cfg.APIKeysFile)
+		if err != nil {
+			return nil, fmt.Errorf("read api keys: %w", err)
+		}
+		var keys []APIKey
+		if err := json.Unmarshal(data, &keys); err != nil {
+			return nil, fmt.Errorf("parse api keys: %w", err)
+		}
+		for _, key := range keys {
+			if key.Name == "" || len(key.Key) < 16 {
+				return nil, fmt.Errorf("api key %q: name and a key of at least 16 characters are required", key.Name)
+			}
+			if key.Tenant != "" && !fsutil.ValidTenant(key.Tenant) {
+				return nil, fmt.Errorf("api key %q: invalid tenant %q", key.Name, key.Tenant)
+			}
+			// keyed by hash so lookups don't leak the key through timing
+			authenticator.keys[sha256.Sum256([]byte(key.Key))] = key
+		}
+	}
+	if cfg.JWTSecret != "" || cfg.JWKSFile != "" {
+		verifier, err := newJWTVerifier(cfg)
+		if err != nil {
+			return nil, err
+		}
+		authenticator.jwt = verifier
+	}
+
+	return authenticator, nil
+}
+
+// Authenticate reads an API key (X-API-Key, or a bearer token without dots)
+// or a JWT bearer token from the request.
+func (authenticator *Authenticator) Authenticate(request *http.Request) (*Principal, error) {
+	token := request.Header.Get("X-API-Key")
+	if auth := request.Header.Get("Authorization"); token == "" && strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(auth, "Bearer "))
+	}
+	if token == "" {
+		return nil, ErrNoCredentials
+	}
+
+	if strings.Count(token, ".") == 2 {
+		if authenticator.jwt == nil {
+			return nil, ErrInvalidToken
+		}
+		return authenticator.jwt.verify(token)
+	}
+
+	key, ok := authenticator.keys[sha256.Sum256([]byte(token))]
+	if !ok || subtle.ConstantTimeCompare([]byte(key.Key), []byte(token)) != 1 {
+		return nil, ErrInvalidKey
+	}
+
+	return &Principal{ID: "key:" + key.Name, Method: "api_key", Scopes: key.Scopes, Tenant: key.Tenant}, nil
+}
@@ -0,0 +1,195 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"upload/internal/config"
+	"upload/internal/fsutil"
+)
+
+var ErrInvalidToken = errors.New("invalid token")
+
+// leeway tolerates clock skew between us and the token issuer.
+const leeway = time.Minute
+
+// jwtVerifier checks HS256 tokens against JWT_SECRET and RS256 tokens
+// against the RSA keys of JWT_JWKS_FILE. Each algorithm is only accepted
+// when its key material is configured, so an RS256 public key can never be
+// used as an HS256 secret.
+type jwtVerifier struct {
+	secret   []byte
+	keys     map[string]*rsa.PublicKey // by kid
+	issuer   string
+	audience string
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+func newJWTVerifier(cfg config.Config) (*jwtVerifier, error) {
+	verifier := &jwtVerifier{
+		secret:   []byte(cfg.JWTSecret),
+		keys:     map[string]*rsa.PublicKey{},
+		issuer:   cfg.JWTIssuer,
+		audience: cfg.JWTAudience,
+	}
+	if cfg.JWKSFile == "" {
+		return verifier, nil
+	}
+
+	data, err := os.ReadFile(cfg.JWKSFile)
+	if err != nil {
+		return nil, fmt.Errorf("read jwks: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("parse jwks: %w", err)
+	}
+	for _, key := range set.Keys {
+		if key.Kty != "RSA" || (key.Use != "" && key.Use != "sig") {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", key.Kid, err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			return nil, fmt.Errorf("jwks key %q: %w", key.Kid, err)
+		}
+		verifier.keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	if len(verifier.keys) == 0 {
+		return nil, errors.New("jwks has no RSA signing keys")
+	}
+
+	return verifier, nil
+}
+
+type claims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // string or array
+	ExpiresAt *int64          `json:"exp"`
+	NotBefore *int64          `json:"nbf"`
+	Scope     string          `json:"scope"`  // space separated (RFC 8693)
+	Scopes    []string        `json:"scopes"` // or an array
+	Tenant    string          `json:"tenant"`
+}
+
+func (verifier *jwtVerifier) verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+
+	switch header.Alg {
+	case "HS256":
+		if len(verifier.secret) == 0 {
+			return nil, ErrInvalidToken
+		}
+		mac := hmac.New(sha256.New, verifier.secret)
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, ErrInvalidToken
+		}
+	case "RS256":
+		key, ok := verifier.keys[header.Kid]
+		if !ok && header.Kid == "" && len(verifier.keys) == 1 {
+			for _, only := range verifier.keys {
+				key, ok = only, true
+			}
+		}
+		if !ok {
+			return nil, ErrInvalidToken
+		}
+		digest := sha256.Sum256(signed)
+		if rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) != nil {
+			return nil, ErrInvalidToken
+		}
+	default:
+		return nil, ErrInvalidToken
+	}
+
+	var c claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, ErrInvalidToken
+	}
+	now := time.Now()
+	if c.ExpiresAt == nil || now.After(time.Unix(*c.ExpiresAt, 0).Add(leeway)) {
+		return nil, fmt.Errorf("%w: expired", ErrInvalidToken)
+	}
+	if c.NotBefore != nil && now.Add(leeway).Before(time.Unix(*c.NotBefore, 0)) {
+		return nil, fmt.Errorf("%w: not yet valid", ErrInvalidToken)
+	}
+	if verifier.issuer != "" && c.Issuer != verifier.issuer {
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidToken)
+	}
+	if verifier.audience != "" && !audienceContains(c.Audience, verifier.audience) {
+		return nil, fmt.Errorf("%w: wrong audience", ErrInvalidToken)
+	}
+	if c.Subject == "" {
+		return nil, fmt.Errorf("%w: missing sub", ErrInvalidToken)
+	}
+	if c.Tenant != "" && !fsutil.ValidTenant(c.Tenant) {
+		return nil, fmt.Errorf("%w: invalid tenant", ErrInvalidToken)
+	}
+
+	scopes := c.Scopes
+	if c.Scope != "" {
+		scopes = append(scopes, strings.Fields(c.Scope)...)
+	}
+
+	return &Principal{ID: "jwt:" + c.Issuer + ":" + c.Subject, Method: "jwt", Scopes: scopes, Tenant: c.Tenant}, nil
+}
+
+func decodeSegment(segment string, v any) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func audienceContains(raw json.RawMessage, audience string) bool {
+	var single string
+	if json.Unmarshal(raw, &single) == nil {
+		return single == audience
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) == nil {
+		return slices.Contains(list, audience)
+	}
+	return false
+}
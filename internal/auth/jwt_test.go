@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"slices"
+	"testing"
+	"time"
+)
+
+// makeToken signs header and claims with HS256 (secret) or RS256 (key) as
+// header["alg"] says; any other alg gets an empty signature.
+func makeToken(t *testing.T, header, claims map[string]any, secret []byte, key *rsa.PrivateKey) string {
+	t.Helper()
+	segment := func(v any) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(header) + "." + segment(claims)
+
+	var signature []byte
+	switch header["alg"] {
+	case "HS256":
+		mac := hmac.New(sha256.New, secret)
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+		var err error
+		signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestJWTVerify(t *testing.T) {
+	secret := []byte("jwt-secret")
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hsOnly := &jwtVerifier{secret: secret, keys: map[string]*rsa.PublicKey{}}
+	rsOnly := &jwtVerifier{keys: map[string]*rsa.PublicKey{"k1": &rsaKey.PublicKey}}
+	scoped := &jwtVerifier{secret: secret, keys: map[string]*rsa.PublicKey{}, issuer: "https://id.example.com", audience: "videos"}
+
+	now := time.Now()
+	hs := map[string]any{"alg": "HS256", "typ": "JWT"}
+	claims := func(extra map[string]any) map[string]any {
+		c := map[string]any{"sub": "alice", "exp": now.Add(time.Hour).Unix(), "scope": "read upload"}
+		for k, v := range extra {
+			if v == nil {
+				delete(c, k)
+				continue
+			}
+			c[k] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name     string
+		verifier *jwtVerifier
+		token    string
+		err      bool
+	}{
+		{name: "hs256", verifier: hsOnly, token: makeToken(t, hs, claims(nil), secret, nil)},
+		{name: "hs256 wrong secret", verifier: hsOnly, token: makeToken(t, hs, claims(nil), []byte("other"), nil), err: true},
+		{name: "alg none", verifier: hsOnly, token: makeToken(t, map[string]any{"alg": "none"}, claims(nil), nil, nil), err: true},
+		{name: "rs256", verifier: rsOnly, token: makeToken(t, map[string]any{"alg": "RS256", "kid": "k1"}, claims(nil), nil, rsaKey)},
+		{name: "rs256 single key without kid", verifier: rsOnly, token: makeToken(t, map[string]any{"alg": "RS256"}, claims(nil), nil, rsaKey)},
+		{name: "rs256 unknown kid", verifier: rsOnly, token: makeToken(t, map[string]any{"alg": "RS256", "kid": "k2"}, claims(nil), nil, rsaKey), err: true},
+		{name: "rs256 other key", verifier: rsOnly, token: makeToken(t, map[string]any{"alg": "RS256", "kid": "k1"}, claims(nil), nil, otherKey), err: true},
+		{name: "rs256 without jwks", verifier: hsOnly, token: makeToken(t, map[string]any{"alg": "RS256"}, claims(nil), nil, rsaKey), err: true},
+		{name: "hs256 without secret", verifier: rsOnly, token: makeToken(t, hs, claims(nil), nil, nil), err: true},
+		{name: "expired", verifier: hsOnly, token: makeToken(t, hs, claims(map[string]any{"exp": now.Add(-2 * leeway).Unix()}), secret, nil), err: true},
+		{name: "expired within leeway", verifier: hsOnly, token: makeToken(t, hs, claims(map[string]any{"exp": now.Add(-leeway / 2).Unix()}), secret, nil)},
+		{name: "no exp", verifier: hsOnly, token: makeToken(t, hs, claims(map[string]any{"exp": nil}), secret, nil), err: true},
+		{name: "not yet valid", verifier: hsOnly, token: makeToken(t, hs, claims(map[string]any{"nbf": now.Add(2 * leeway).Unix()}), secret, nil), err: true},
+		{name: "nbf within leeway", verifier: hsOnly, token: makeToken(t, hs, claims(map[string]any{"nbf": now.Add(leeway / 2).Unix()}), secret, nil)},
+		{name: "no sub", verifier: hsOnly, token: makeToken(t, hs, claims(map[string]any{"sub": nil}), secret, nil), err: true},
+		{name: "invalid tenant", verifier: hsOnly, token: makeToken(t, hs, claims(map[string]any{"tenant": "../etc"}), secret, nil), err: true},
+		{name: "issuer and audience", verifier: scoped, token: makeToken(t, hs, claims(map[string]any{"iss": "https://id.example.com", "aud": "videos"}), secret, nil)},
+		{name: "audience list", verifier: scoped, token: makeToken(t, hs, claims(map[string]any{"iss": "https://id.example.com", "aud": []string{"billing", "videos"}}), secret, nil)},
+		{name: "wrong issuer", verifier: scoped, token: makeToken(t, hs, claims(map[string]any{"iss": "https://evil.example.com", "aud": "videos"}), secret, nil), err: true},
+		{name: "wrong audience", verifier: scoped, token: makeToken(t, hs, claims(map[string]any{"iss": "https://id.example.com", "aud": "billing"}), secret, nil), err: true},
+		{name: "no audience", verifier: scoped, token: makeToken(t, hs, claims(map[string]any{"iss": "https://id.example.com"}), secret, nil), err: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			principal, err := test.verifier.verify(test.token)
+			if test.err {
+				if !errors.Is(err, ErrInvalidToken) {
+					t.Fatalf("verify() error = %v, want %v", err, ErrInvalidToken)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verify() error = %v", err)
+			}
+			if principal.Method != "jwt" || !slices.Equal(principal.Scopes, []string{"read", "upload"}) {
+				t.Fatalf("verify() principal = %+v", principal)
+			}
+		})
+	}
+}
+
+func TestJWTPrincipalID(t *testing.T) {
+	secret := []byte("jwt-secret")
+	verifier := &jwtVerifier{secret: secret, keys: map[string]*rsa.PublicKey{}}
+	token := makeToken(t, map[string]any{"alg": "HS256"}, map[string]any{
+		"sub": "alice", "iss": "https://id.example.com", "exp": time.Now().Add(time.Hour).Unix(), "tenant": "acme",
+	}, secret, nil)
+
+	principal, err := verifier.verify(token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// namespaced so a token subject can't match an API key name
+	if principal.ID != "jwt:https://id.example.com:alice" || principal.Tenant != "acme" {
+		t.Fatalf("verify() principal = %+v", principal)
+	}
+}
@@ -0,0 +1,145 @@
+// Package collection groups videos into ordered collections, e.g. the
+// lessons of a course, with a cover image and a generated HLS playlist.
+package collection
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"upload/internal/meta"
+)
+
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 5000
+	MaxVideos            = 500
+)
+
+var ErrInvalid = errors.New("invalid collection")
+
+// coverPattern matches the thumbnail paths recorded by the thumbnail
+// generator: thumbnails/<video id>/thumb_NNN.jpg or poster.jpg.
+var coverPattern = regexp.MustCompile(`^thumbnails/([0-9a-f-]{36})/(thumb_[0-9]{3}|poster)\.jpg$`)
+
+type Collection struct {
+	ID          string    `json:"id"`
+	Tenant      string    `json:"tenant,omitempty"`
+	Owner       string    `json:"owner,omitempty"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Visibility  string    `json:"visibility,omitempty"` // as meta.Details: "" is private
+	VideoIDs    []string  `json:"video_ids"`            // in playback order
+	Cover       string    `json:"cover,omitempty"`      // thumbnails/<video id>/<file>.jpg
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+// Edit is a partial update; nil fields are left alone. An empty Cover
+// removes the cover.
+type Edit struct {
+	Title       *string   `json:"title"`
+	Description *string   `json:"description"`
+	Visibility  *string   `json:"visibility"`
+	VideoIDs    *[]string `json:"video_ids"`
+	Cover       *string   `json:"cover"`
+}
+
+// Apply validates the edit and applies it to collection, which is untouched
+// on error. Membership is checked by the caller: videos must exist and be
+// visible to whoever edits.
+func (edit Edit) Apply(collection *Collection) error {
+	updated := *collection
+	if edit.Title != nil {
+		updated.Title = strings.TrimSpace(*edit.Title)
+	}
+	if edit.Description != nil {
+		updated.Description = strings.TrimSpace(*edit.Description)
+	}
+	if edit.Visibility != nil {
+		updated.Visibility = *edit.Visibility
+	}
+	if edit.VideoIDs != nil {
+		updated.VideoIDs = slices.Clone(*edit.VideoIDs)
+	}
+	if edit.Cover != nil {
+		updated.Cover = *edit.Cover
+	}
+	if err := updated.validate(); err != nil {
+		return err
+	}
+
+	*collection = updated
+	return nil
+}
+
+func (collection *Collection) validate() error {
+	if collection.Title == "" {
+		return fmt.Errorf("%w: title is required", ErrInvalid)
+	}
+	if utf8.RuneCountInString(collection.Title) > MaxTitleLength {
+		return fmt.Errorf("%w: title is longer than %d characters", ErrInvalid, MaxTitleLength)
+	}
+	if utf8.RuneCountInString(collection.Description) > MaxDescriptionLength {
+		return fmt.Errorf("%w: description is longer than %d characters", ErrInvalid, MaxDescriptionLength)
+	}
+	switch collection.Visibility {
+	case "", meta.VisibilityPrivate, meta.VisibilityUnlisted, meta.VisibilityPublic:
+	default:
+		return fmt.Errorf("%w: visibility must be private, unlisted or public", ErrInvalid)
+	}
+	if collection.VideoIDs == nil {
+		collection.VideoIDs = []string{}
+	}
+	if len(collection.VideoIDs) > MaxVideos {
+		return fmt.Errorf("%w: more than %d videos", ErrInvalid, MaxVideos)
+	}
+	for i, vid := range collection.VideoIDs {
+		if slices.Contains(collection.VideoIDs[:i], vid) {
+			return fmt.Errorf("%w: video %s is listed twice", ErrInvalid, vid)
+		}
+	}
+	if collection.Cover != "" {
+		vid, ok := CoverVideo(collection.Cover)
+		if !ok {
+			return fmt.Errorf("%w: cover must be a thumbnail path such as thumbnails/<id>/thumb_001.jpg", ErrInvalid)
+		}
+		if !slices.Contains(collection.VideoIDs, vid) {
+			return fmt.Errorf("%w: cover must be a thumbnail of a video in the collection", ErrInvalid)
+		}
+	}
+
+	return nil
+}
+
+// CoverVideo returns the video a cover thumbnail path belongs to.
+func CoverVideo(cover string) (string, bool) {
+	match := coverPattern.FindStringSubmatch(cover)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// Shared reports whether others in the tenant may open the collection.
+func (collection Collection) Shared() bool {
+	return collection.Visibility == meta.VisibilityPublic || collection.Visibility == meta.VisibilityUnlisted
+}
+
+// Without drops vid from the collection, and the cover if it was one of
+// vid's thumbnails. It reports whether anything changed.
+func (collection *Collection) Without(vid string) bool {
+	index := slices.Index(collection.VideoIDs, vid)
+	if index == -1 {
+		return false
+	}
+	collection.VideoIDs = slices.Delete(collection.VideoIDs, index, index+1)
+	if cover, _ := CoverVideo(collection.Cover); cover == vid {
+		collection.Cover = ""
+	}
+	return true
+}
@@ -0,0 +1,248 @@
+package collection
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"upload/internal/fsutil"
+	"upload/internal/hls"
+	"upload/internal/meta"
+)
+
+// ErrNotPlayable is returned when a collection has no playable videos or
+// their renditions can't be chained.
+var ErrNotPlayable = errors.New("collection is not playable")
+
+// AudioRendition names the concatenated audio playlist of collections whose
+// videos have separate audio renditions.
+const AudioRendition = "audio"
+
+// audioGroup is the GROUP-ID the transcoder gives a video's per-track audio
+// renditions.
+const audioGroup = "aud"
+
+// Playable keeps the ready videos, in collection order.
+func Playable(videos []meta.Metadata) []meta.Metadata {
+	var playable []meta.Metadata
+	for _, m := range videos {
+		if m.Status == "ready" && len(videoVariants(m)) > 0 {
+			playable = append(playable, m)
+		}
+	}
+	return playable
+}
+
+// MasterPlaylist offers one variant per height found in any video; each
+// plays every video at that height or the nearest one below it. Media
+// playlist URIs are relative: hls/<height>.m3u8 and hls/audio.m3u8.
+func MasterPlaylist(videos []meta.Metadata) (string, error) {
+	if len(videos) == 0 {
+		return "", fmt.Errorf("%w: no ready videos", ErrNotPlayable)
+	}
+	separate, err := separateAudio(videos)
+	if err != nil {
+		return "", err
+	}
+
+	master := hls.MasterPlaylist{}
+	var audioCodecs []string
+	audioPeak := 0
+	if separate {
+		for _, m := range videos {
+			audio, _ := audioVariant(m)
+			audioCodecs = append(audioCodecs, audio.Codecs)
+			audioPeak = max(audioPeak, bandwidthOf(audio))
+		}
+		master.Media = append(master.Media, hls.Media{
+			Type:       "AUDIO",
+			GroupID:    audioGroup,
+			Name:       "Audio",
+			Default:    true,
+			AutoSelect: true,
+			URI:        "hls/" + AudioRendition + ".m3u8",
+		})
+	}
+
+	for _, height := range heights(videos) {
+		stream := hls.VariantStream{URI: fmt.Sprintf("hls/%d.m3u8", height)}
+		var codecs []string
+		var sizes []string
+		for _, m := range videos {
+			v, _ := videoVariant(m, height)
+			stream.Bandwidth = max(stream.Bandwidth, bandwidthOf(v))
+			stream.FrameRate = max(stream.FrameRate, v.FrameRate)
+			codecs = append(codecs, v.Codecs)
+			sizes = append(sizes, fmt.Sprintf("%dx%d", v.Width, v.EncodedHeight))
+			if v.Container == "fmp4" {
+				master.Version = 7
+			}
+		}
+		// only advertised when every video agrees
+		if uniform(codecs) && (!separate || uniform(audioCodecs)) {
+			stream.Codecs = codecs[0]
+			if separate {
+				stream.Codecs = hls.JoinCodecs(codecs[0], audioCodecs[0])
+			}
+		}
+		if uniform(sizes) {
+			v, _ := videoVariant(videos[0], height)
+			stream.Width, stream.Height = v.Width, v.EncodedHeight
+		}
+		if separate {
+			stream.Audio = audioGroup
+			stream.Bandwidth += audioPeak
+		}
+		master.Variants = append(master.Variants, stream)
+	}
+
+	return master.String(), nil
+}
+
+// MediaPlaylist chains rendition (a height from the master playlist, or
+// "audio") of every video, separated by discontinuities. root is the tenant
+// storage root of a video; segments are referenced under /streams/<id>/.
+func MediaPlaylist(videos []meta.Metadata, rendition string, root func(m meta.Metadata) string) (string, error) {
+	if len(videos) == 0 {
+		return "", fmt.Errorf("%w: no ready videos", ErrNotPlayable)
+	}
+	height := 0
+	if rendition != AudioRendition {
+		var err error
+		height, err = strconv.Atoi(rendition)
+		if err != nil || !slices.Contains(heights(videos), height) {
+			return "", fmt.Errorf("%w: no %s rendition", ErrNotPlayable, rendition)
+		}
+	} else if separate, err := separateAudio(videos); err != nil || !separate {
+		return "", fmt.Errorf("%w: no separate audio", ErrNotPlayable)
+	}
+
+	items := make([]hls.ConcatItem, 0, len(videos))
+	for _, m := range videos {
+		var v meta.Variant
+		if height == 0 {
+			v, _ = audioVariant(m)
+		} else {
+			v, _ = videoVariant(m, height)
+		}
+		playlistPath := filepath.Join(fsutil.OutputsDir(root(m), m.ID), filepath.FromSlash(v.PathOrPl))
+		playlist, err := hls.ReadMediaPlaylist(playlistPath)
+		if err != nil {
+			return "", fmt.Errorf("read %s of %s: %w", v.PathOrPl, m.ID, err)
+		}
+		items = append(items, hls.ConcatItem{
+			Playlist: playlist,
+			BaseURI:  "/streams/" + m.ID + "/" + strings.TrimPrefix(path.Dir(v.PathOrPl)+"/", "./"),
+		})
+	}
+
+	return hls.Concat(items), nil
+}
+
+// videoVariants are the HLS video renditions of m.
+func videoVariants(m meta.Metadata) []meta.Variant {
+	var variants []meta.Variant
+	for _, v := range m.Variants {
+		if v.Format == "hls" && v.Kind == "" {
+			variants = append(variants, v)
+		}
+	}
+	return variants
+}
+
+// videoVariant picks the rendition of m closest to height from below, or
+// the smallest one if all are taller. H.264 wins among equal heights, as
+// the most widely playable codec.
+func videoVariant(m meta.Metadata, height int) (meta.Variant, bool) {
+	var best meta.Variant
+	found := false
+	better := func(v meta.Variant) bool {
+		switch {
+		case !found:
+			return true
+		case v.Height == best.Height:
+			return v.Codec == "h264" && best.Codec != "h264"
+		case v.Height <= height && best.Height <= height:
+			return v.Height > best.Height
+		case v.Height <= height:
+			return true
+		case best.Height > height:
+			return v.Height < best.Height
+		}
+		return false
+	}
+	for _, v := range videoVariants(m) {
+		if better(v) {
+			best, found = v, true
+		}
+	}
+	return best, found
+}
+
+// audioVariant is the default track of m's separate audio renditions.
+func audioVariant(m meta.Metadata) (meta.Variant, bool) {
+	var first *meta.Variant
+	for i, v := range m.Variants {
+		if v.Format != "hls" || v.Kind != "audio" || v.Group != audioGroup {
+			continue
+		}
+		if v.Default {
+			return v, true
+		}
+		if first == nil {
+			first = &m.Variants[i]
+		}
+	}
+	if first == nil {
+		return meta.Variant{}, false
+	}
+	return *first, true
+}
+
+// separateAudio reports whether the videos carry audio as separate
+// renditions. A mix of muxed and separate audio can't be chained.
+func separateAudio(videos []meta.Metadata) (bool, error) {
+	count := 0
+	for _, m := range videos {
+		if _, ok := audioVariant(m); ok {
+			count++
+		}
+	}
+	if count > 0 && count < len(videos) {
+		return false, fmt.Errorf("%w: videos mix muxed and separate audio", ErrNotPlayable)
+	}
+	return count > 0, nil
+}
+
+func heights(videos []meta.Metadata) []int {
+	var all []int
+	for _, m := range videos {
+		for _, v := range videoVariants(m) {
+			if !slices.Contains(all, v.Height) {
+				all = append(all, v.Height)
+			}
+		}
+	}
+	slices.Sort(all)
+	return all
+}
+
+func uniform(values []string) bool {
+	for _, v := range values {
+		if v == "" || v != values[0] {
+			return false
+		}
+	}
+	return len(values) > 0
+}
+
+func bandwidthOf(v meta.Variant) int {
+	if v.BandwidthPeak > 0 {
+		return v.BandwidthPeak
+	}
+	return v.BitrateKbps * 1000
+}
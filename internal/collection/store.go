@@ -0,0 +1,154 @@
+package collection
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Store keeps one JSON file per collection under StorageDir/collections.
+type Store struct {
+	root string
+	mu   sync.Mutex // serializes Modify
+}
+
+func NewStore(root string) *Store {
+	return &Store{root: root}
+}
+
+func (store *Store) pathFor(id string) string {
+	return filepath.Join(store.root, fmt.Sprintf("%s.json", id))
+}
+
+func (store *Store) Create(collection Collection) error {
+	if err := collection.validate(); err != nil {
+		return err
+	}
+	now := time.Now()
+	collection.CreatedAt = now
+	collection.UpdatedAt = now
+
+	return writeFileAtomic(store.pathFor(collection.ID), collection)
+}
+
+func (store *Store) Get(id string) (Collection, error) {
+	data, err := os.ReadFile(store.pathFor(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return Collection{}, fs.ErrNotExist
+	}
+	if err != nil {
+		return Collection{}, err
+	}
+	var collection Collection
+	if err := json.Unmarshal(data, &collection); err != nil {
+		return Collection{}, err
+	}
+
+	return collection, nil
+}
+
+// List returns every collection, newest first.
+func (store *Store) List() ([]Collection, error) {
+	entries, err := os.ReadDir(store.root)
+	if errors.Is(err, fs.ErrNotExist) {
+		return []Collection{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	collections := make([]Collection, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(store.root, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var collection Collection
+		if err := json.Unmarshal(data, &collection); err == nil {
+			collections = append(collections, collection)
+		}
+	}
+	sort.Slice(collections, func(i, j int) bool {
+		return collections[i].CreatedAt.After(collections[j].CreatedAt)
+	})
+
+	return collections, nil
+}
+
+// Modify applies fn to the stored collection and writes it back; concurrent
+// modifications of the same store don't lose each other's changes.
+func (store *Store) Modify(id string, fn func(collection *Collection) error) (Collection, error) {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	collection, err := store.Get(id)
+	if err != nil {
+		return Collection{}, err
+	}
+	if err := fn(&collection); err != nil {
+		return Collection{}, err
+	}
+	if err := collection.validate(); err != nil {
+		return Collection{}, err
+	}
+	collection.UpdatedAt = time.Now()
+	if err := writeFileAtomic(store.pathFor(id), collection); err != nil {
+		return Collection{}, err
+	}
+
+	return collection, nil
+}
+
+func (store *Store) Delete(id string) error {
+	err := os.Remove(store.pathFor(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fs.ErrNotExist
+	}
+	return err
+}
+
+// RemoveVideo takes a deleted video out of every collection.
+func (store *Store) RemoveVideo(vid string) error {
+	collections, err := store.List()
+	if err != nil {
+		return err
+	}
+	for _, collection := range collections {
+		if !collection.Without(vid) {
+			continue
+		}
+		if _, err := store.Modify(collection.ID, func(c *Collection) error {
+			c.Without(vid)
+			return nil
+		}); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// writeFileAtomic writes JSON to a temp file then renames it into place.
+func writeFileAtomic(dest string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	tmp := dest + ".tmp"
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	_ = os.Remove(dest)
+	return os.Rename(tmp, dest)
+}
@@ -4,6 +4,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 )
 
 // GetEnv returns env var or default when empty.
@@ -22,6 +23,82 @@ type Config struct {
 	MaxUploadMB int
 	Workers     int
 	AllowedMIME []string
+	Ladder      string // [mode=single-pass,]height[:encoder[:bitrate]],... empty = built-in H.264 ladder
+	DASHEnabled bool   // also package renditions as MPEG-DASH
+	SegmentType string // HLS segments: mpegts or fmp4 (CMAF)
+	MP4Download bool   // also remux each height into a faststart MP4 for download
+
+	// AudioMode "muxed" keeps the first audio track inside every video
+	// rendition; "separate" packages every track as its own HLS audio
+	// rendition. AudioOnlyBitrate adds a low-bitrate audio-only rendition in
+	// separate mode ("off" disables it).
+	AudioMode        string
+	AudioBitrate     string
+	AudioOnlyBitrate string
+
+	// HLS encryption: "aes-128" encrypts every segment with per-video keys,
+	// changing key every KeyRotation segments (0 = one key per rendition).
+	// KeyToken authorizes GET /videos/:id/keys/:n while no API credentials
+	// are configured; otherwise keys go to credentials or signed URLs only.
+	Encryption  string
+	KeyRotation int
+	KeyToken    string
+
+	// Signed URLs: with a secret, /streams, /thumbnails, master.m3u8 and
+	// downloads require exp/sig query parameters. SigningTTL is the default
+	// lifetime of issued URLs. TrustProxy takes the client IP (for IP-bound
+	// signatures) from X-Forwarded-For instead of the connection.
+	SigningSecret string
+	SigningTTL    time.Duration
+	TrustProxy    bool
+
+	// API authentication. With none of these set the API is open.
+	// APIKeysFile is a JSON array of {name, key, scopes, tenant}; JWTSecret
+	// enables HS256 and JWKSFile RS256 bearer tokens (tenant claim).
+	APIKeysFile string
+	JWTSecret   string
+	JWKSFile    string
+	JWTIssuer   string // required iss, if set
+	JWTAudience string // required aud, if set
+
+	// Tenant quotas: JSON array of {name, storage_quota_mb,
+	// encoding_minutes_per_month}. Unlisted tenants and 0 are unlimited.
+	TenantsFile string
+
+	// URL / local path imports
+	ImportAllowedHosts []string
+	ImportAllowedDirs  []string
+	ImportTimeout      time.Duration
+	ImportMaxMB        int
+
+	// Scrub-bar sprite sheets: a SpriteWidth px wide tile every
+	// SpriteInterval, SpriteColumns x SpriteRows tiles per sheet.
+	// SpriteInterval 0 disables them.
+	SpriteInterval time.Duration
+	SpriteWidth    int
+	SpriteColumns  int
+	SpriteRows     int
+
+	// Animated hover previews (animated WebP and silent MP4): PreviewSnippets
+	// snippets of PreviewSnippetDuration, at most PreviewMaxDuration in
+	// total and PreviewMaxKB per file. PreviewMode is "even" or "scene".
+	PreviewEnabled         bool
+	PreviewMode            string
+	PreviewSnippets        int
+	PreviewSnippetDuration time.Duration
+	PreviewMaxDuration     time.Duration
+	PreviewWidth           int
+	PreviewMaxKB           int
+
+	// On-demand frames (GET /videos/:id/frame) are cached on disk up to
+	// FrameCacheMB, least recently used first out.
+	FrameCacheMB int
+	FrameWorkers int // concurrent frame extractions; more get 503
+
+	// Storage consistency checks; GCInterval 0 disables the scheduled scan
+	GCInterval time.Duration
+	GCRepair   bool
+	GCMinAge   time.Duration
 }
 
 // Load reads configuration from environment with sensible defaults.
@@ -45,18 +122,94 @@ func Load() Config {
 			cfg.Workers = n
 		}
 	}
-	if v := os.Getenv("ALLOWED_MIME"); v != "" {
-		parts := strings.Split(v, ",")
-		out := make([]string, 0, len(parts))
-		for _, p := range parts {
-			s := strings.TrimSpace(p)
-			if s != "" {
-				out = append(out, s)
-			}
+	if out := splitList(os.Getenv("ALLOWED_MIME")); len(out) > 0 {
+		cfg.AllowedMIME = out
+	}
+	cfg.Ladder = os.Getenv("LADDER")
+	cfg.DASHEnabled = getBool("DASH_ENABLED", false)
+	cfg.SegmentType = GetEnv("HLS_SEGMENT_TYPE", "mpegts")
+	cfg.MP4Download = getBool("MP4_DOWNLOAD", false)
+	cfg.AudioMode = GetEnv("AUDIO_MODE", "muxed")
+	cfg.AudioBitrate = GetEnv("AUDIO_BITRATE", "128k")
+	cfg.AudioOnlyBitrate = GetEnv("AUDIO_ONLY_BITRATE", "64k")
+	if cfg.AudioOnlyBitrate == "off" {
+		cfg.AudioOnlyBitrate = ""
+	}
+	cfg.Encryption = strings.ToLower(GetEnv("HLS_ENCRYPTION", "none"))
+	cfg.KeyRotation = getInt("HLS_KEY_ROTATION", 0)
+	cfg.KeyToken = os.Getenv("HLS_KEY_TOKEN")
+	cfg.SigningSecret = os.Getenv("URL_SIGNING_SECRET")
+	cfg.SigningTTL = getDuration("URL_SIGNING_TTL", time.Hour)
+	cfg.TrustProxy = getBool("TRUST_PROXY", false)
+	cfg.APIKeysFile = os.Getenv("API_KEYS_FILE")
+	cfg.JWTSecret = os.Getenv("JWT_SECRET")
+	cfg.JWKSFile = os.Getenv("JWT_JWKS_FILE")
+	cfg.JWTIssuer = os.Getenv("JWT_ISSUER")
+	cfg.JWTAudience = os.Getenv("JWT_AUDIENCE")
+	cfg.TenantsFile = os.Getenv("TENANTS_FILE")
+	cfg.ImportAllowedHosts = splitList(os.Getenv("IMPORT_ALLOWED_HOSTS"))
+	cfg.ImportAllowedDirs = splitList(os.Getenv("IMPORT_ALLOWED_DIRS"))
+	cfg.ImportTimeout = getDuration("IMPORT_TIMEOUT", 30*time.Minute)
+	cfg.ImportMaxMB = getInt("IMPORT_MAX_MB", cfg.MaxUploadMB)
+	cfg.SpriteInterval = getDuration("SPRITE_INTERVAL", 10*time.Second)
+	cfg.SpriteWidth = getInt("SPRITE_WIDTH", 160)
+	cfg.SpriteColumns = getInt("SPRITE_COLUMNS", 10)
+	cfg.SpriteRows = getInt("SPRITE_ROWS", 10)
+	cfg.PreviewEnabled = getBool("PREVIEW_ENABLED", false)
+	cfg.PreviewMode = GetEnv("PREVIEW_MODE", "even")
+	cfg.PreviewSnippets = getInt("PREVIEW_SNIPPETS", 5)
+	cfg.PreviewSnippetDuration = getDuration("PREVIEW_SNIPPET_DURATION", time.Second)
+	cfg.PreviewMaxDuration = getDuration("PREVIEW_MAX_DURATION", 6*time.Second)
+	cfg.PreviewWidth = getInt("PREVIEW_WIDTH", 320)
+	cfg.PreviewMaxKB = getInt("PREVIEW_MAX_KB", 1024)
+	cfg.FrameCacheMB = getInt("FRAME_CACHE_MB", 256)
+	cfg.FrameWorkers = getInt("FRAME_WORKERS", max(cfg.Workers, 2))
+	cfg.GCInterval = getDuration("GC_INTERVAL", 0)
+	cfg.GCRepair = getBool("GC_REPAIR", false)
+	cfg.GCMinAge = getDuration("GC_MIN_AGE", time.Hour)
+	return cfg
+}
+
+// splitList parses a comma separated env value, dropping empty entries.
+func splitList(v string) []string {
+	if v == "" {
+		return nil
+	}
+	parts := strings.Split(v, ",")
+	out := make([]string, 0, len(parts))
+	for _, p := range parts {
+		s := strings.TrimSpace(p)
+		if s != "" {
+			out = append(out, s)
 		}
-		if len(out) > 0 {
-			cfg.AllowedMIME = out
+	}
+	return out
+}
+
+func getInt(key string, def int) int {
+	if v := os.Getenv(key); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			return n
 		}
 	}
-	return cfg
+	return def
+}
+
+func getBool(key string, def bool) bool {
+	if v := os.Getenv(key); v != "" {
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return def
+}
+
+// getDuration accepts Go durations ("90s", "5m").
+func getDuration(key string, def time.Duration) time.Duration {
+	if v := os.Getenv(key); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			return d
+		}
+	}
+	return def
 }
@@ -0,0 +1,203 @@
+package dash
+
+import (
+	"encoding/xml"
+	"fmt"
+	"math"
+	"os"
+	"strings"
+
+	"upload/internal/hls"
+)
+
+// Representation points at segments that already exist on disk (e.g. the
+// fMP4 segments of an HLS rendition), so the MPD costs no extra storage.
+type Representation struct {
+	ID        string
+	BaseURL   string // relative to the MPD, ends with "/"
+	Bandwidth int
+	Width     int
+	Height    int
+	Codecs    string
+	FrameRate float64
+	InitURI   string
+	Segments  []hls.Segment
+}
+
+// AdaptationSet groups interchangeable representations (e.g. all video
+// renditions, or one audio language).
+type AdaptationSet struct {
+	ContentType     string // video, audio, text
+	Lang            string
+	Representations []Representation
+}
+
+type Manifest struct {
+	DurationSec    float64
+	AdaptationSets []AdaptationSet
+}
+
+const timescale = 1000
+
+type mpdXML struct {
+	XMLName                   xml.Name  `xml:"MPD"`
+	Xmlns                     string    `xml:"xmlns,attr"`
+	Profiles                  string    `xml:"profiles,attr"`
+	Type                      string    `xml:"type,attr"`
+	MediaPresentationDuration string    `xml:"mediaPresentationDuration,attr"`
+	MinBufferTime             string    `xml:"minBufferTime,attr"`
+	Period                    periodXML `xml:"Period"`
+}
+
+type periodXML struct {
+	ID             string             `xml:"id,attr"`
+	Start          string             `xml:"start,attr"`
+	AdaptationSets []adaptationSetXML `xml:"AdaptationSet"`
+}
+
+type adaptationSetXML struct {
+	ID               int                 `xml:"id,attr"`
+	ContentType      string              `xml:"contentType,attr,omitempty"`
+	Lang             string              `xml:"lang,attr,omitempty"`
+	MimeType         string              `xml:"mimeType,attr"`
+	SegmentAlignment bool                `xml:"segmentAlignment,attr"`
+	Representations  []representationXML `xml:"Representation"`
+}
+
+type representationXML struct {
+	ID          string         `xml:"id,attr"`
+	Bandwidth   int            `xml:"bandwidth,attr"`
+	Width       int            `xml:"width,attr,omitempty"`
+	Height      int            `xml:"height,attr,omitempty"`
+	Codecs      string         `xml:"codecs,attr,omitempty"`
+	FrameRate   string         `xml:"frameRate,attr,omitempty"`
+	BaseURL     string         `xml:"BaseURL"`
+	SegmentList segmentListXML `xml:"SegmentList"`
+}
+
+type segmentListXML struct {
+	Timescale      int             `xml:"timescale,attr"`
+	Initialization *urlXML         `xml:"Initialization,omitempty"`
+	Timeline       []timelineXML   `xml:"SegmentTimeline>S"`
+	SegmentURLs    []segmentURLXML `xml:"SegmentURL"`
+}
+
+type urlXML struct {
+	SourceURL string `xml:"sourceURL,attr"`
+}
+
+type timelineXML struct {
+	T *int64 `xml:"t,attr,omitempty"`
+	D int64  `xml:"d,attr"`
+	R int    `xml:"r,attr,omitempty"`
+}
+
+type segmentURLXML struct {
+	Media string `xml:"media,attr"`
+}
+
+// WriteFile renders the manifest as a static MPD using SegmentList addressing.
+func (manifest Manifest) WriteFile(path string) error {
+	doc := mpdXML{
+		Xmlns:                     "urn:mpeg:dash:schema:mpd:2011",
+		Profiles:                  "urn:mpeg:dash:profile:full:2011",
+		Type:                      "static",
+		MediaPresentationDuration: isoDuration(manifest.DurationSec),
+		MinBufferTime:             "PT4S",
+		Period:                    periodXML{ID: "0", Start: "PT0S"},
+	}
+
+	for i, set := range manifest.AdaptationSets {
+		setXML := adaptationSetXML{
+			ID:               i,
+			ContentType:      set.ContentType,
+			Lang:             set.Lang,
+			MimeType:         mimeFor(set.ContentType),
+			SegmentAlignment: true,
+		}
+		for _, rep := range set.Representations {
+			repXML := representationXML{
+				ID:        rep.ID,
+				Bandwidth: rep.Bandwidth,
+				Width:     rep.Width,
+				Height:    rep.Height,
+				Codecs:    rep.Codecs,
+				BaseURL:   rep.BaseURL,
+				SegmentList: segmentListXML{
+					Timescale: timescale,
+					Timeline:  timeline(rep.Segments),
+				},
+			}
+			if rep.FrameRate > 0 {
+				repXML.FrameRate = frameRate(rep.FrameRate)
+			}
+			if rep.InitURI != "" {
+				repXML.SegmentList.Initialization = &urlXML{SourceURL: rep.InitURI}
+			}
+			for _, segment := range rep.Segments {
+				repXML.SegmentList.SegmentURLs = append(repXML.SegmentList.SegmentURLs, segmentURLXML{Media: segment.URI})
+			}
+			setXML.Representations = append(setXML.Representations, repXML)
+		}
+		doc.Period.AdaptationSets = append(doc.Period.AdaptationSets, setXML)
+	}
+
+	b, err := xml.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append([]byte(xml.Header), b...)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	// On Windows, rename over existing fails; remove first.
+	_ = os.Remove(path)
+	return os.Rename(tmp, path)
+}
+
+// timeline run-length encodes segment durations into S elements.
+func timeline(segments []hls.Segment) []timelineXML {
+	var out []timelineXML
+	var start int64
+	for i, segment := range segments {
+		d := int64(math.Round(segment.Duration * timescale))
+		if n := len(out); n > 0 && out[n-1].D == d {
+			out[n-1].R++
+		} else {
+			s := timelineXML{D: d}
+			if i == 0 {
+				t := start
+				s.T = &t
+			}
+			out = append(out, s)
+		}
+		start += d
+	}
+	return out
+}
+
+func mimeFor(contentType string) string {
+	switch contentType {
+	case "audio":
+		return "audio/mp4"
+	case "text":
+		return "application/mp4"
+	}
+	return "video/mp4"
+}
+
+// frameRate expresses common NTSC rates as fractions, as the schema prefers.
+func frameRate(fps float64) string {
+	for _, base := range []int{24, 30, 60} {
+		if math.Abs(fps-float64(base)*1000/1001) < 0.01 {
+			return fmt.Sprintf("%d/1001", base*1000)
+		}
+	}
+	return strings.TrimSuffix(strings.TrimRight(fmt.Sprintf("%.3f", fps), "0"), ".")
+}
+
+func isoDuration(sec float64) string {
+	return fmt.Sprintf("PT%.3fS", sec)
+}
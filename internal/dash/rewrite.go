@@ -0,0 +1,21 @@
+package dash
+
+import (
+	"html"
+	"regexp"
+)
+
+// urlAttribute matches the URL attributes of SegmentTemplate, SegmentURL and
+// Initialization elements.
+var urlAttribute = regexp.MustCompile(`\b(media|initialization|sourceURL)="([^"]*)"`)
+
+// RewriteURLs passes every segment and initialization URL (or URL template)
+// in an MPD through rewrite. Values are XML-unescaped before and escaped
+// after.
+func RewriteURLs(mpd []byte, rewrite func(url string) string) []byte {
+	return urlAttribute.ReplaceAllFunc(mpd, func(match []byte) []byte {
+		parts := urlAttribute.FindSubmatch(match)
+		url := rewrite(html.UnescapeString(string(parts[2])))
+		return []byte(string(parts[1]) + `="` + html.EscapeString(url) + `"`)
+	})
+}
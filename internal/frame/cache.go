@@ -0,0 +1,223 @@
+package frame
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrBusy is returned when every generation slot is taken.
+var ErrBusy = errors.New("too many frames being generated")
+
+// fillTimeout bounds one frame extraction. It runs detached from the
+// request that started it, so a client hanging up doesn't fail the others
+// waiting for the same frame; it is canceled once all of them are gone.
+const fillTimeout = time.Minute
+
+// Cache keeps generated files in dir and evicts the least recently used
+// ones once they take more than maxBytes. Concurrent requests for the same
+// name share one generation, and at most workers generations run at once.
+type Cache struct {
+	dir      string
+	maxBytes int64
+	slots    chan struct{}
+
+	mu       sync.Mutex
+	lru      *list.List               // of *entry, most recently used first
+	entries  map[string]*list.Element // by file name
+	size     int64
+	inflight map[string]*call
+	seq      int // numbers temporary files, so an abandoned call can't clash with a fresh one
+}
+
+type entry struct {
+	name string
+	size int64
+}
+
+type call struct {
+	tmp     string
+	done    chan struct{}
+	err     error
+	waiters int
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+// NewCache indexes the files already in dir, oldest modification first, and
+// drops partial files left by a crash.
+func NewCache(dir string, maxBytes int64, workers int) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	cache := &Cache{
+		dir:      dir,
+		maxBytes: maxBytes,
+		slots:    make(chan struct{}, max(workers, 1)),
+		lru:      list.New(),
+		entries:  map[string]*list.Element{},
+		inflight: map[string]*call{},
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []os.FileInfo
+	for _, dirEntry := range dirEntries {
+		info, err := dirEntry.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if strings.Contains(info.Name(), ".tmp") {
+			os.Remove(filepath.Join(dir, info.Name()))
+			continue
+		}
+		files = append(files, info)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().After(files[j].ModTime()) })
+	for _, info := range files {
+		cache.entries[info.Name()] = cache.lru.PushBack(&entry{name: info.Name(), size: info.Size()})
+		cache.size += info.Size()
+	}
+	cache.mu.Lock()
+	cache.evict()
+	cache.mu.Unlock()
+
+	return cache, nil
+}
+
+// Get returns the path of the cached file name, calling fill to write it to
+// a temporary path first if it isn't cached yet. It fails with ErrBusy
+// rather than queue when all generation slots are taken.
+func (cache *Cache) Get(context context.Context, name string, fill func(context context.Context, path string) error) (string, error) {
+	p := filepath.Join(cache.dir, name)
+
+	cache.mu.Lock()
+	if element, ok := cache.entries[name]; ok {
+		now := time.Now()
+		// touching keeps the order across restarts; a file removed behind
+		// the cache's back is generated again
+		if err := os.Chtimes(p, now, now); err == nil {
+			cache.lru.MoveToFront(element)
+			cache.mu.Unlock()
+			return p, nil
+		}
+		cache.remove(element)
+	}
+	c, ok := cache.inflight[name]
+	if !ok {
+		select {
+		case cache.slots <- struct{}{}:
+		default:
+			cache.mu.Unlock()
+			return "", ErrBusy
+		}
+		cache.seq++
+		ext := filepath.Ext(name)
+		c = newCall(fmt.Sprintf("%s.%d.tmp%s", strings.TrimSuffix(p, ext), cache.seq, ext))
+		cache.inflight[name] = c
+		go cache.fill(name, c, fill)
+	}
+	c.waiters++
+	cache.mu.Unlock()
+
+	select {
+	case <-c.done:
+		if c.err != nil {
+			return "", c.err
+		}
+		return p, nil
+	case <-context.Done():
+		cache.mu.Lock()
+		c.waiters--
+		if c.waiters == 0 {
+			// later requests start over instead of joining a canceled call
+			c.cancel()
+			delete(cache.inflight, name)
+		}
+		cache.mu.Unlock()
+		return "", context.Err()
+	}
+}
+
+func newCall(tmp string) *call {
+	ctx, cancel := context.WithTimeout(context.Background(), fillTimeout)
+	return &call{tmp: tmp, done: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+func (cache *Cache) fill(name string, c *call, fill func(context context.Context, path string) error) {
+	defer close(c.done)
+	defer func() { <-cache.slots }()
+	defer c.cancel()
+
+	c.err = fill(c.ctx, c.tmp)
+
+	// abandoning a call happens under mu too, so an abandoned call never
+	// replaces the file of the fresh call that took its place
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if cache.inflight[name] == c {
+		delete(cache.inflight, name)
+	}
+	if c.err == nil {
+		c.err = c.ctx.Err()
+	}
+	p := filepath.Join(cache.dir, name)
+	var info os.FileInfo
+	if c.err == nil {
+		c.err = os.Rename(c.tmp, p)
+	}
+	if c.err == nil {
+		info, c.err = os.Stat(p)
+	}
+	if c.err != nil {
+		os.Remove(c.tmp)
+		return
+	}
+	cache.entries[name] = cache.lru.PushFront(&entry{name: name, size: info.Size()})
+	cache.size += info.Size()
+	cache.evict()
+}
+
+// evict removes least recently used files until the cache fits maxBytes,
+// always keeping the newest one. Callers hold mu.
+func (cache *Cache) evict() {
+	for cache.size > cache.maxBytes && cache.lru.Len() > 1 {
+		cache.remove(cache.lru.Back())
+	}
+}
+
+func (cache *Cache) remove(element *list.Element) {
+	e := element.Value.(*entry)
+	os.Remove(filepath.Join(cache.dir, e.name))
+	cache.lru.Remove(element)
+	delete(cache.entries, e.name)
+	cache.size -= e.size
+}
+
+// Forget removes every cached file whose name starts with prefix, e.g. the
+// frames of a deleted video.
+func (cache *Cache) Forget(prefix string) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	for name, element := range cache.entries {
+		if strings.HasPrefix(name, prefix) {
+			cache.remove(element)
+		}
+	}
+}
+
+// Size is the total size of the cached files.
+func (cache *Cache) Size() int64 {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	return cache.size
+}
@@ -0,0 +1,57 @@
+package frame
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"upload/internal/fsutil"
+	"upload/internal/meta"
+)
+
+// ErrNoSource is returned when neither the original nor a usable rendition
+// of the video is on disk.
+var ErrNoSource = errors.New("no source to extract frames from")
+
+// Source picks the file to take a width px wide frame from: the smallest
+// rendition at least that wide (MP4 before HLS, which seeks slower), else
+// the original, else the widest rendition. Encrypted HLS renditions are left
+// out since ffmpeg can't fetch their keys.
+func Source(root string, m meta.Metadata, width int) (string, error) {
+	var renditions []meta.Variant
+	for _, v := range m.Variants {
+		if v.Kind != "" || !(v.Format == "mp4" || (v.Format == "hls" && m.Encryption == "")) {
+			continue
+		}
+		renditions = append(renditions, v)
+	}
+	sort.SliceStable(renditions, func(i, j int) bool {
+		if renditions[i].Width != renditions[j].Width {
+			return renditions[i].Width < renditions[j].Width
+		}
+		return renditions[i].Format == "mp4" && renditions[j].Format != "mp4"
+	})
+
+	outputs := fsutil.OutputsDir(root, m.ID)
+	for _, v := range renditions {
+		if p := filepath.Join(outputs, filepath.FromSlash(v.PathOrPl)); v.Width >= width && exists(p) {
+			return p, nil
+		}
+	}
+	if p := fsutil.OriginalPath(root, m.ID, m.OriginalFilename); m.Status != "importing" && exists(p) {
+		return p, nil
+	}
+	for i := len(renditions) - 1; i >= 0; i-- {
+		if p := filepath.Join(outputs, filepath.FromSlash(renditions[i].PathOrPl)); exists(p) {
+			return p, nil
+		}
+	}
+
+	return "", ErrNoSource
+}
+
+func exists(p string) bool {
+	_, err := os.Stat(p)
+	return err == nil
+}
@@ -0,0 +1,426 @@
+package fsck
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"upload/internal/fsutil"
+	"upload/internal/hls"
+	"upload/internal/meta"
+)
+
+type Kind string
+
+const (
+	KindOrphanDir       Kind = "orphan_dir"       // per-video directory without metadata
+	KindTempFile        Kind = "temp_file"        // stale .tmp/.part from an interrupted write
+	KindPartialOutput   Kind = "partial_output"   // output files no variant refers to
+	KindMissingOriginal Kind = "missing_original" // metadata whose original is gone
+	KindCorruptOriginal Kind = "corrupt_original" // size or checksum mismatch
+	KindMissingMaster   Kind = "missing_master"   // ready video without master.m3u8
+	KindBrokenVariant   Kind = "broken_variant"   // playlist or segments missing
+)
+
+type Issue struct {
+	Kind    Kind   `json:"kind"`
+	VideoID string `json:"video_id,omitempty"`
+	Path    string `json:"path,omitempty"`
+	Detail  string `json:"detail"`
+}
+
+func (issue Issue) String() string {
+	if issue.VideoID != "" {
+		return fmt.Sprintf("%s %s: %s", issue.Kind, issue.VideoID, issue.Detail)
+	}
+	return fmt.Sprintf("%s: %s", issue.Kind, issue.Detail)
+}
+
+type Report struct {
+	ScannedAt time.Time `json:"scanned_at"`
+	Videos    int       `json:"videos"`
+	Issues    []Issue   `json:"issues"`
+}
+
+type Options struct {
+	MinAge   time.Duration // leave temp files and orphans younger than this alone
+	Checksum bool          // re-hash originals
+	IDs      []string      // only check these videos and skip the orphan sweep
+}
+
+type RepairOptions struct {
+	DryRun bool
+	// DeleteMissing removes metadata whose original is gone instead of only
+	// marking it failed.
+	DeleteMissing bool
+}
+
+// Checker cross-references metadata with originals/, outputs/ and thumbnails/.
+type Checker struct {
+	root  string
+	store meta.Store
+}
+
+func NewChecker(root string, store meta.Store) *Checker {
+	return &Checker{root: root, store: store}
+}
+
+func (checker *Checker) Scan(options Options) (Report, error) {
+	report := Report{ScannedAt: time.Now(), Issues: []Issue{}}
+
+	videos, err := checker.store.List()
+	if err != nil {
+		return report, err
+	}
+
+	if len(options.IDs) > 0 {
+		byID := make(map[string]meta.Metadata, len(videos))
+		for _, v := range videos {
+			byID[v.ID] = v
+		}
+		for _, vid := range options.IDs {
+			m, ok := byID[vid]
+			if !ok {
+				return report, fmt.Errorf("video %s: %w", vid, fs.ErrNotExist)
+			}
+			report.Videos++
+			report.Issues = append(report.Issues, checker.checkVideo(m, options)...)
+		}
+		return report, nil
+	}
+
+	known := make(map[string]bool, len(videos))
+	for _, m := range videos {
+		known[filepath.Join(checker.rootOf(m), m.ID)] = true
+		report.Videos++
+		report.Issues = append(report.Issues, checker.checkVideo(m, options)...)
+	}
+
+	orphans, err := checker.orphans(known, options.MinAge)
+	report.Issues = append(report.Issues, orphans...)
+
+	return report, err
+}
+
+// busy videos are still being written; their outputs are not judged.
+func busy(m meta.Metadata) bool {
+	return m.Status == "importing" || m.Status == "queued" || m.Status == "processing"
+}
+
+// rootOf is the tenant root holding m's files.
+func (checker *Checker) rootOf(m meta.Metadata) string {
+	return fsutil.TenantRoot(checker.root, m.Tenant)
+}
+
+func (checker *Checker) checkVideo(m meta.Metadata, options Options) []Issue {
+	var issues []Issue
+	add := func(kind Kind, path, format string, args ...any) {
+		issues = append(issues, Issue{Kind: kind, VideoID: m.ID, Path: path, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	if m.Status != "importing" {
+		original := fsutil.OriginalPath(checker.rootOf(m), m.ID, m.OriginalFilename)
+		info, err := os.Stat(original)
+		switch {
+		case err != nil:
+			add(KindMissingOriginal, original, "original missing: %s", original)
+		case m.SizeBytes > 0 && info.Size() != m.SizeBytes:
+			add(KindCorruptOriginal, original, "original size %d, metadata says %d", info.Size(), m.SizeBytes)
+		case options.Checksum && m.ChecksumSHA256 != "":
+			if sum, err := sha256File(original); err != nil {
+				add(KindCorruptOriginal, original, "read original: %v", err)
+			} else if sum != m.ChecksumSHA256 {
+				add(KindCorruptOriginal, original, "original checksum mismatch")
+			}
+		}
+	}
+
+	if busy(m) {
+		return issues
+	}
+
+	outputDir := fsutil.OutputsDir(checker.rootOf(m), m.ID)
+	if m.Status != "ready" {
+		// failed or canceled jobs leave whatever renditions they finished
+		if _, err := os.Stat(outputDir); err == nil {
+			add(KindPartialOutput, outputDir, "outputs left by %s job", m.Status)
+		}
+		return issues
+	}
+
+	masterPath := filepath.Join(outputDir, "master.m3u8")
+	if _, err := os.Stat(masterPath); err != nil {
+		add(KindMissingMaster, masterPath, "master.m3u8 missing")
+	}
+
+	for _, v := range m.Variants {
+		p := filepath.Join(outputDir, filepath.FromSlash(v.PathOrPl))
+		switch v.Format {
+		case "hls":
+			for _, problem := range verifyPlaylist(p) {
+				add(KindBrokenVariant, p, "%dp: %s", v.Height, problem)
+			}
+		default:
+			if _, err := os.Stat(p); err != nil {
+				add(KindBrokenVariant, p, "%s %dp: %s missing", v.Format, v.Height, v.PathOrPl)
+			}
+		}
+	}
+
+	referenced := referencedOutputs(m)
+	entries, _ := os.ReadDir(outputDir)
+	for _, entry := range entries {
+		if !referenced[entry.Name()] {
+			p := filepath.Join(outputDir, entry.Name())
+			add(KindPartialOutput, p, "%s is not referenced by any variant", entry.Name())
+		}
+	}
+
+	return issues
+}
+
+// referencedOutputs returns the top-level names under outputs/<id> that the
+// metadata accounts for.
+func referencedOutputs(m meta.Metadata) map[string]bool {
+	referenced := map[string]bool{"master.m3u8": true}
+	for _, v := range m.Variants {
+		first, _, _ := strings.Cut(v.PathOrPl, "/")
+		referenced[first] = true
+	}
+	return referenced
+}
+
+func verifyPlaylist(path string) []string {
+	playlist, err := hls.ReadMediaPlaylist(path)
+	if err != nil {
+		return []string{fmt.Sprintf("playlist: %v", err)}
+	}
+
+	var problems []string
+	dir := filepath.Dir(path)
+	uris := make([]string, 0, len(playlist.Segments)+1)
+	if playlist.InitURI != "" {
+		uris = append(uris, playlist.InitURI)
+	}
+	for _, segment := range playlist.Segments {
+		uris = append(uris, segment.URI)
+	}
+	for _, uri := range uris {
+		if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(uri))); err != nil {
+			problems = append(problems, fmt.Sprintf("segment missing: %s", uri))
+		}
+	}
+	if len(playlist.Segments) == 0 {
+		problems = append(problems, "playlist has no segments")
+	}
+
+	return problems
+}
+
+// orphans finds per-video directories without a metadata record in their
+// tenant root and stale .tmp/.part files. known holds <tenant root>/<id>.
+func (checker *Checker) orphans(known map[string]bool, minAge time.Duration) ([]Issue, error) {
+	var issues []Issue
+	cutoff := time.Now().Add(-minAge)
+	orphanDirs := map[string]bool{}
+
+	roots, err := fsutil.TenantRoots(checker.root)
+	if err != nil {
+		return issues, err
+	}
+	for _, root := range roots {
+		for _, area := range fsutil.Areas {
+			entries, err := os.ReadDir(filepath.Join(root, area))
+			if errors.Is(err, fs.ErrNotExist) {
+				continue
+			}
+			if err != nil {
+				return issues, err
+			}
+			for _, entry := range entries {
+				if !entry.IsDir() || known[filepath.Join(root, entry.Name())] {
+					continue
+				}
+				// the upload handler creates the directory before the metadata
+				if info, err := entry.Info(); err != nil || info.ModTime().After(cutoff) {
+					continue
+				}
+				p := filepath.Join(root, area, entry.Name())
+				rel, _ := filepath.Rel(checker.root, p)
+				orphanDirs[p] = true
+				issues = append(issues, Issue{Kind: KindOrphanDir, Path: p, Detail: fmt.Sprintf("%s has no metadata", filepath.ToSlash(rel))})
+			}
+		}
+	}
+
+	err = filepath.WalkDir(checker.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if orphanDirs[p] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(p, ".tmp") && !strings.HasSuffix(p, ".part") {
+			return nil
+		}
+		if info, err := d.Info(); err != nil || info.ModTime().After(cutoff) {
+			return nil
+		}
+		issues = append(issues, Issue{Kind: KindTempFile, Path: p, Detail: fmt.Sprintf("stale temp file %s", p)})
+		return nil
+	})
+
+	return issues, err
+}
+
+type RepairResult struct {
+	Actions  []string // one line per action taken (or planned, in dry-run)
+	Requeued []string // videos reset to queued that need to be enqueued
+}
+
+// Repair fixes what it can: orphans, temp files and partial outputs are
+// deleted, videos with broken outputs are reset to queued so they get
+// reprocessed, and videos whose original is gone are marked failed (or
+// deleted with DeleteMissing).
+func (checker *Checker) Repair(report Report, options RepairOptions) (RepairResult, error) {
+	var result RepairResult
+	requeue := map[string]bool{}
+	missing := map[string]bool{}
+
+	for _, issue := range report.Issues {
+		switch issue.Kind {
+		case KindOrphanDir, KindTempFile, KindPartialOutput:
+			result.Actions = append(result.Actions, "remove "+issue.Path)
+			if !options.DryRun {
+				if err := os.RemoveAll(issue.Path); err != nil {
+					return result, err
+				}
+			}
+		case KindMissingMaster, KindBrokenVariant:
+			requeue[issue.VideoID] = true
+		case KindMissingOriginal:
+			missing[issue.VideoID] = true
+		}
+	}
+
+	for vid := range missing {
+		delete(requeue, vid) // nothing to reprocess from
+		m, err := checker.store.Get(vid)
+		if err != nil {
+			continue
+		}
+		if options.DeleteMissing {
+			result.Actions = append(result.Actions, "delete "+vid)
+			if options.DryRun {
+				continue
+			}
+			root := checker.rootOf(m)
+			for _, dir := range []string{fsutil.OutputsDir(root, vid), fsutil.ThumbnailsDir(root, vid), fsutil.OriginalsDir(root, vid), fsutil.KeysDir(root, vid)} {
+				os.RemoveAll(dir)
+			}
+			if err := checker.store.Delete(vid); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if m.Status == "failed" && m.ErrorMessage == "original missing" {
+			continue
+		}
+		result.Actions = append(result.Actions, "mark failed "+vid)
+		if !options.DryRun {
+			m.Status = "failed"
+			m.ErrorMessage = "original missing"
+			if err := checker.store.Update(m); err != nil {
+				return result, err
+			}
+		}
+	}
+
+	for vid := range requeue {
+		m, err := checker.store.Get(vid)
+		if err != nil {
+			continue
+		}
+		result.Actions = append(result.Actions, "requeue "+vid)
+		if options.DryRun {
+			continue
+		}
+		os.RemoveAll(fsutil.OutputsDir(checker.rootOf(m), vid))
+		m.Status = "queued"
+		m.ErrorMessage = ""
+		m.Variants = []meta.Variant{}
+		if err := checker.store.Update(m); err != nil {
+			return result, err
+		}
+		result.Requeued = append(result.Requeued, vid)
+	}
+
+	return result, nil
+}
+
+// Schedule scans every interval until ctx is done, logging the report and
+// repairing when repair is non-nil. Requeued videos are passed to enqueue.
+func (checker *Checker) Schedule(ctx context.Context, interval time.Duration, options Options, repair *RepairOptions, enqueue func(videoID string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		report, err := checker.Scan(options)
+		if err != nil {
+			log.Printf("Storage check failed: %v", err)
+			continue
+		}
+		log.Printf("Storage check: %d videos, %d issues", report.Videos, len(report.Issues))
+		for _, issue := range report.Issues {
+			log.Printf("Storage check: %s", issue)
+		}
+		if repair == nil || len(report.Issues) == 0 {
+			continue
+		}
+
+		result, err := checker.Repair(report, *repair)
+		for _, action := range result.Actions {
+			log.Printf("Storage repair: %s", action)
+		}
+		if enqueue != nil {
+			for _, vid := range result.Requeued {
+				enqueue(vid)
+			}
+		}
+		if err != nil {
+			log.Printf("Storage repair failed: %v", err)
+		}
+	}
+}
+
+func sha256File(p string) (string, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
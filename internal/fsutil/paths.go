@@ -1,11 +1,77 @@
 package fsutil
 
-import "path/filepath"
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// TenantRoot is where tenant's originals, outputs, thumbnails and keys live.
+// The default tenant ("") uses root itself, so single-tenant installs keep
+// their layout.
+func TenantRoot(root, tenant string) string {
+	if tenant == "" {
+		return root
+	}
+	return filepath.Join(root, "tenants", tenant)
+}
+
+// TenantRoots lists root and every tenant root under it.
+func TenantRoots(root string) ([]string, error) {
+	roots := []string{root}
+	entries, err := os.ReadDir(filepath.Join(root, "tenants"))
+	if errors.Is(err, fs.ErrNotExist) {
+		return roots, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			roots = append(roots, filepath.Join(root, "tenants", entry.Name()))
+		}
+	}
+	return roots, nil
+}
+
+// ValidTenant reports whether name is usable as a tenant (and directory)
+// name: 1-64 lowercase letters, digits, '-' or '_', starting with a letter
+// or digit.
+func ValidTenant(name string) bool {
+	if name == "" || len(name) > 64 || name[0] == '-' || name[0] == '_' {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
 
 func OriginalsDir(root, id string) string {
 	return filepath.Join(root, "originals", id)
 }
 
+// OriginalPath is where the uploaded source for id lives. Filenames without an
+// extension are stored as .mp4.
+func OriginalPath(root, id, filename string) string {
+	ext := filepath.Ext(filename)
+	if ext == "" {
+		ext = ".mp4"
+	}
+	return filepath.Join(OriginalsDir(root, id), "original"+ext)
+}
+
+// SubtitlePath is where an uploaded caption file for language is kept,
+// converted to WebVTT. It lives with the original so a reprocess, which
+// wipes outputs, can segment it again.
+func SubtitlePath(root, id, language string) string {
+	return filepath.Join(OriginalsDir(root, id), "subtitles", language+".vtt")
+}
+
 func OutputsDir(root, id string) string {
 	return filepath.Join(root, "outputs", id)
 }
@@ -14,6 +80,44 @@ func ThumbnailsDir(root, id string) string {
 	return filepath.Join(root, "thumbnails", id)
 }
 
+// KeysDir holds the HLS encryption keys of id. It is outside outputs/ so
+// keys are never reachable through /streams.
+func KeysDir(root, id string) string {
+	return filepath.Join(root, "keys", id)
+}
+
+// KeyPath is the n-th AES-128 key of id.
+func KeyPath(root, id string, n int) string {
+	return filepath.Join(KeysDir(root, id), fmt.Sprintf("%d.key", n))
+}
+
+func CollectionsDir(root string) string {
+	return filepath.Join(root, "collections")
+}
+
+// FrameCacheDir holds on-demand frames of every tenant; names start with
+// the video id.
+func FrameCacheDir(root string) string {
+	return filepath.Join(root, "cache", "frames")
+}
+
 func MetadataDir(root string) string {
 	return filepath.Join(root, "metadata")
 }
+
+// Areas are the per-video directories of a tenant root.
+var Areas = []string{"originals", "outputs", "thumbnails", "keys"}
+
+// EnsureLayout creates the base storage directories under root.
+func EnsureLayout(root string) error {
+	if err := os.MkdirAll(MetadataDir(root), 0o755); err != nil {
+		return err
+	}
+	for _, area := range Areas {
+		if err := os.MkdirAll(filepath.Join(root, area), 0o755); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
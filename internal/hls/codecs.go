@@ -0,0 +1,146 @@
+package hls
+
+import (
+	"fmt"
+	"strings"
+)
+
+// VideoCodec returns the RFC 6381 codec string for a stream as reported by
+// ffprobe (codec_name, profile, level and dimensions). It returns "" when the
+// codec isn't one we can describe; the CODECS attribute is then left out.
+func VideoCodec(codecName, profile string, level, width, height int) string {
+	switch codecName {
+	case "h264":
+		// avc1.PPCCLL: profile_idc, constraint flags, level_idc
+		var profileIDC, constraints int
+		switch strings.ToLower(profile) {
+		case "constrained baseline":
+			profileIDC, constraints = 66, 0xE0
+		case "baseline":
+			profileIDC, constraints = 66, 0x00
+		case "main":
+			profileIDC, constraints = 77, 0x40
+		case "extended":
+			profileIDC, constraints = 88, 0x00
+		case "high":
+			profileIDC = 100
+		case "high 10":
+			profileIDC = 110
+		case "high 4:2:2":
+			profileIDC = 122
+		case "high 4:4:4 predictive":
+			profileIDC = 244
+		default:
+			return ""
+		}
+		return fmt.Sprintf("avc1.%02X%02X%02X", profileIDC, constraints, level)
+	case "hevc":
+		// hvc1.<profile>.<compatibility>.<tier><level>.<constraints>;
+		// ffprobe's level is general_level_idc (30 * level)
+		switch strings.ToLower(profile) {
+		case "main":
+			return fmt.Sprintf("hvc1.1.6.L%d.B0", level)
+		case "main 10":
+			return fmt.Sprintf("hvc1.2.4.L%d.B0", level)
+		}
+		return ""
+	case "vp9":
+		// vp09.<profile>.<level>.<bit depth>; ffprobe doesn't report the
+		// level, so derive it from the picture size
+		vp9Profile := 0
+		if _, err := fmt.Sscanf(profile, "Profile %d", &vp9Profile); err != nil {
+			vp9Profile = 0
+		}
+		return fmt.Sprintf("vp09.%02d.%02d.08", vp9Profile, vp9Level(width*height))
+	case "av1":
+		// av01.<profile>.<seq_level_idx><tier>.<bit depth>
+		av1Profile := 0
+		switch strings.ToLower(profile) {
+		case "high":
+			av1Profile = 1
+		case "professional":
+			av1Profile = 2
+		}
+		return fmt.Sprintf("av01.%d.%02dM.08", av1Profile, av1Level(width*height))
+	}
+
+	return ""
+}
+
+// vp9Level picks the lowest VP9 level whose max picture size fits.
+func vp9Level(pixels int) int {
+	switch {
+	case pixels <= 36864:
+		return 10
+	case pixels <= 122880:
+		return 20
+	case pixels <= 245760:
+		return 21
+	case pixels <= 552960:
+		return 30
+	case pixels <= 983040:
+		return 31
+	case pixels <= 2228224:
+		return 40
+	case pixels <= 8912896:
+		return 50
+	}
+	return 60
+}
+
+// av1Level returns seq_level_idx for the lowest AV1 level whose max picture
+// size fits (2.0=0, 2.1=1, 3.0=4, 3.1=5, 4.0=8, 5.0=12, 6.0=16).
+func av1Level(pixels int) int {
+	switch {
+	case pixels <= 147456:
+		return 0
+	case pixels <= 278784:
+		return 1
+	case pixels <= 665856:
+		return 4
+	case pixels <= 1065024:
+		return 5
+	case pixels <= 2359296:
+		return 8
+	case pixels <= 8912896:
+		return 12
+	}
+	return 16
+}
+
+// AudioCodec returns the RFC 6381 codec string for an audio stream.
+func AudioCodec(codecName, profile string) string {
+	switch codecName {
+	case "aac":
+		switch strings.ToLower(profile) {
+		case "he-aac":
+			return "mp4a.40.5"
+		case "he-aacv2":
+			return "mp4a.40.29"
+		}
+		return "mp4a.40.2" // AAC-LC
+	case "mp3":
+		return "mp4a.40.34"
+	case "ac3":
+		return "ac-3"
+	case "eac3":
+		return "ec-3"
+	case "opus":
+		return "Opus"
+	case "flac":
+		return "fLaC"
+	}
+
+	return ""
+}
+
+// JoinCodecs joins the non-empty codec strings for a CODECS attribute.
+func JoinCodecs(codecs ...string) string {
+	out := codecs[:0:0]
+	for _, c := range codecs {
+		if c != "" {
+			out = append(out, c)
+		}
+	}
+	return strings.Join(out, ",")
+}
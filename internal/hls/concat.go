@@ -0,0 +1,75 @@
+package hls
+
+import (
+	"fmt"
+	"math"
+	"strings"
+)
+
+// ConcatItem is one media playlist of a concatenation. Relative segment,
+// init and key URIs are resolved against BaseURI, e.g. "/streams/<id>/720p/".
+type ConcatItem struct {
+	Playlist *MediaPlaylist
+	BaseURI  string
+}
+
+// Concat renders a VOD media playlist that plays items back to back, with an
+// EXT-X-DISCONTINUITY before every item but the first. Encrypted segments get
+// an EXT-X-KEY with an explicit IV each: renumbering the segments would
+// change the implicit one.
+func Concat(items []ConcatItem) string {
+	target := 1
+	version := 3
+	for _, item := range items {
+		target = max(target, item.Playlist.TargetDuration)
+		for _, segment := range item.Playlist.Segments {
+			target = max(target, int(math.Ceil(segment.Duration)))
+		}
+		if item.Playlist.InitURI != "" {
+			version = 6
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", version, target)
+	encrypted := false
+	for i, item := range items {
+		if i > 0 {
+			b.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		if item.Playlist.InitURI != "" {
+			// the init section is in the clear
+			if encrypted {
+				b.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+				encrypted = false
+			}
+			fmt.Fprintf(&b, "#EXT-X-MAP:URI=%q\n", resolve(item.BaseURI, item.Playlist.InitURI))
+		}
+		for n, segment := range item.Playlist.Segments {
+			switch {
+			case segment.Key != "":
+				attrs := Attributes(segment.Key)
+				iv := attrs["IV"]
+				if iv == "" {
+					iv = fmt.Sprintf("0x%032X", item.Playlist.MediaSequence+n)
+				}
+				fmt.Fprintf(&b, "#EXT-X-KEY:METHOD=%s,URI=%q,IV=%s\n", attrs["METHOD"], resolve(item.BaseURI, attrs["URI"]), iv)
+				encrypted = true
+			case encrypted:
+				b.WriteString("#EXT-X-KEY:METHOD=NONE\n")
+				encrypted = false
+			}
+			fmt.Fprintf(&b, "#EXTINF:%.6f,\n%s\n", segment.Duration, resolve(item.BaseURI, segment.URI))
+		}
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+
+	return b.String()
+}
+
+func resolve(base, uri string) string {
+	if uri == "" || strings.HasPrefix(uri, "/") || strings.Contains(uri, "://") {
+		return uri
+	}
+	return base + uri
+}
@@ -0,0 +1,132 @@
+package hls
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// KeySize is the AES-128 key length.
+const KeySize = 16
+
+// EncryptOptions describes how a media playlist is encrypted with
+// METHOD=AES-128.
+type EncryptOptions struct {
+	// Keys are used in turn, each for Rotation segments; with Rotation 0
+	// the first key covers every segment.
+	Keys     [][]byte
+	Rotation int
+	// KeyURI is written as the URI of the EXT-X-KEY for key n.
+	KeyURI func(n int) string
+}
+
+// KeysNeeded is how many keys a playlist of segments needs for rotation.
+func KeysNeeded(segments, rotation int) int {
+	if rotation <= 0 || segments == 0 {
+		return 1
+	}
+	return (segments + rotation - 1) / rotation
+}
+
+// EncryptMediaPlaylist encrypts every segment of the playlist at path in
+// place (AES-128-CBC, PKCS#7) and inserts EXT-X-KEY tags. The IV is left
+// implicit, so it is the media sequence number as RFC 8216 specifies. An
+// fMP4 initialization section stays in the clear: the first EXT-X-KEY comes
+// after EXT-X-MAP.
+func EncryptMediaPlaylist(path string, options EncryptOptions) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if bytes.Contains(data, []byte("#EXT-X-KEY:")) {
+		return fmt.Errorf("%s is already encrypted", path)
+	}
+
+	dir := filepath.Dir(path)
+	var out strings.Builder
+	sequence := 0
+	segment := 0
+	var pending []string // tags of the next segment, EXTINF included
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			fmt.Sscanf(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"), "%d", &sequence)
+			out.WriteString(line + "\n")
+		case strings.HasPrefix(line, "#EXTINF:"), strings.HasPrefix(line, "#EXT-X-DISCONTINUITY"):
+			pending = append(pending, line)
+		case line == "" || strings.HasPrefix(line, "#"):
+			if len(pending) > 0 {
+				pending = append(pending, line)
+			} else if line != "" {
+				out.WriteString(line + "\n")
+			}
+		default:
+			n := 0
+			if options.Rotation > 0 {
+				n = segment / options.Rotation
+			}
+			if n >= len(options.Keys) {
+				return fmt.Errorf("segment %d needs key %d, only %d given", segment, n, len(options.Keys))
+			}
+			if segment == 0 || (options.Rotation > 0 && segment%options.Rotation == 0) {
+				fmt.Fprintf(&out, "#EXT-X-KEY:METHOD=AES-128,URI=%q\n", options.KeyURI(n))
+			}
+			if err := encryptFile(filepath.Join(dir, filepath.FromSlash(line)), options.Keys[n], uint64(sequence+segment)); err != nil {
+				return err
+			}
+			for _, tag := range pending {
+				out.WriteString(tag + "\n")
+			}
+			pending = nil
+			out.WriteString(line + "\n")
+			segment++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	for _, tag := range pending {
+		out.WriteString(tag + "\n")
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(out.String()), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func encryptFile(path string, key []byte, sequence uint64) error {
+	plain, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+
+	padding := aes.BlockSize - len(plain)%aes.BlockSize
+	buf := make([]byte, len(plain)+padding)
+	copy(buf, plain)
+	for i := len(plain); i < len(buf); i++ {
+		buf[i] = byte(padding)
+	}
+	iv := make([]byte, aes.BlockSize)
+	binary.BigEndian.PutUint64(iv[8:], sequence)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(buf, buf)
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
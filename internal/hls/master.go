@@ -0,0 +1,137 @@
+package hls
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// VariantStream is one EXT-X-STREAM-INF entry.
+type VariantStream struct {
+	URI              string
+	Bandwidth        int // peak segment bitrate, bits/s
+	AverageBandwidth int // bits/s
+	Width            int
+	Height           int
+	Codecs           string
+	FrameRate        float64
+	Audio            string // GROUP-ID of the EXT-X-MEDIA audio renditions to pair with
+	Subtitles        string // GROUP-ID of the EXT-X-MEDIA subtitle renditions
+	AudioOnly        bool   // listed after the video variants so players don't start on it
+}
+
+// Media is an EXT-X-MEDIA rendition, e.g. an alternative audio track.
+type Media struct {
+	Type       string // AUDIO, SUBTITLES
+	GroupID    string
+	Name       string
+	Language   string
+	Default    bool
+	AutoSelect bool
+	Forced     bool // SUBTITLES only: shown even when captions are off
+	Channels   string
+	URI        string
+}
+
+type MasterPlaylist struct {
+	Version  int
+	Media    []Media
+	Variants []VariantStream
+}
+
+// String renders the playlist with variants ordered by bandwidth, lowest
+// first, and audio-only variants last. Optional attributes are left out when
+// unknown.
+func (playlist MasterPlaylist) String() string {
+	version := playlist.Version
+	if version == 0 {
+		version = 3
+	}
+
+	variants := append([]VariantStream(nil), playlist.Variants...)
+	sort.SliceStable(variants, func(i, j int) bool {
+		if variants[i].AudioOnly != variants[j].AudioOnly {
+			return !variants[i].AudioOnly
+		}
+		return variants[i].Bandwidth < variants[j].Bandwidth
+	})
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:%d\n#EXT-X-INDEPENDENT-SEGMENTS\n\n", version)
+	for _, m := range playlist.Media {
+		attrs := []string{
+			"TYPE=" + m.Type,
+			fmt.Sprintf("GROUP-ID=%q", m.GroupID),
+			fmt.Sprintf("NAME=%q", m.Name),
+		}
+		if m.Language != "" {
+			attrs = append(attrs, fmt.Sprintf("LANGUAGE=%q", m.Language))
+		}
+		attrs = append(attrs, "DEFAULT="+yesNo(m.Default), "AUTOSELECT="+yesNo(m.AutoSelect || m.Default))
+		if m.Type == "SUBTITLES" {
+			attrs = append(attrs, "FORCED="+yesNo(m.Forced))
+		}
+		if m.Channels != "" {
+			attrs = append(attrs, fmt.Sprintf("CHANNELS=%q", m.Channels))
+		}
+		attrs = append(attrs, fmt.Sprintf("URI=%q", m.URI))
+		fmt.Fprintf(&b, "#EXT-X-MEDIA:%s\n", strings.Join(attrs, ","))
+	}
+	if len(playlist.Media) > 0 {
+		b.WriteString("\n")
+	}
+
+	for _, v := range variants {
+		attrs := []string{fmt.Sprintf("BANDWIDTH=%d", v.Bandwidth)}
+		if v.AverageBandwidth > 0 {
+			attrs = append(attrs, fmt.Sprintf("AVERAGE-BANDWIDTH=%d", v.AverageBandwidth))
+		}
+		if v.Codecs != "" {
+			attrs = append(attrs, fmt.Sprintf("CODECS=%q", v.Codecs))
+		}
+		if v.Width > 0 && v.Height > 0 {
+			attrs = append(attrs, fmt.Sprintf("RESOLUTION=%dx%d", v.Width, v.Height))
+		}
+		if v.FrameRate > 0 {
+			attrs = append(attrs, fmt.Sprintf("FRAME-RATE=%.3f", v.FrameRate))
+		}
+		if v.Audio != "" {
+			attrs = append(attrs, fmt.Sprintf("AUDIO=%q", v.Audio))
+		}
+		if v.Subtitles != "" {
+			attrs = append(attrs, fmt.Sprintf("SUBTITLES=%q", v.Subtitles))
+		}
+		fmt.Fprintf(&b, "#EXT-X-STREAM-INF:%s\n%s\n", strings.Join(attrs, ","), v.URI)
+	}
+
+	return b.String()
+}
+
+func yesNo(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
+
+// iso6392 maps the three-letter tags containers use to the RFC 5646 tags
+// HLS expects. Unknown tags are passed through.
+var iso6392 = map[string]string{
+	"eng": "en", "kor": "ko", "jpn": "ja", "chi": "zh", "zho": "zh",
+	"spa": "es", "fre": "fr", "fra": "fr", "ger": "de", "deu": "de",
+	"ita": "it", "por": "pt", "rus": "ru", "ara": "ar", "hin": "hi",
+	"vie": "vi", "tha": "th", "ind": "id", "dut": "nl", "nld": "nl",
+}
+
+// Language converts a container language tag to RFC 5646. "und" and empty
+// tags return "".
+func Language(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if tag == "" || tag == "und" {
+		return ""
+	}
+	if mapped, ok := iso6392[tag]; ok {
+		return mapped
+	}
+	return tag
+}
@@ -0,0 +1,118 @@
+package hls
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+type Segment struct {
+	URI      string
+	Duration float64
+	Key      string // attribute list of the EXT-X-KEY in effect; "" when clear
+}
+
+// MediaPlaylist is the subset of an RFC 8216 media playlist we produce with
+// ffmpeg's hls muxer.
+type MediaPlaylist struct {
+	TargetDuration int
+	MediaSequence  int
+	InitURI        string // EXT-X-MAP, fMP4 only
+	Segments       []Segment
+}
+
+func ReadMediaPlaylist(path string) (*MediaPlaylist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseMediaPlaylist(f)
+}
+
+func ParseMediaPlaylist(r io.Reader) (*MediaPlaylist, error) {
+	scanner := bufio.NewScanner(r)
+	playlist := &MediaPlaylist{}
+	var pending float64
+	var key string
+	first := true
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if first {
+			if line != "#EXTM3U" {
+				return nil, fmt.Errorf("missing #EXTM3U header")
+			}
+			first = false
+			continue
+		}
+
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-TARGETDURATION:"):
+			playlist.TargetDuration, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-TARGETDURATION:"))
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			playlist.MediaSequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXT-X-KEY:"):
+			key = strings.TrimPrefix(line, "#EXT-X-KEY:")
+			if Attributes(key)["METHOD"] == "NONE" {
+				key = ""
+			}
+		case strings.HasPrefix(line, "#EXT-X-MAP:"):
+			playlist.InitURI = Attributes(strings.TrimPrefix(line, "#EXT-X-MAP:"))["URI"]
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value := strings.TrimPrefix(line, "#EXTINF:")
+			if index := strings.Index(value, ","); index != -1 {
+				value = value[:index]
+			}
+			pending, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#"):
+		default:
+			playlist.Segments = append(playlist.Segments, Segment{URI: line, Duration: pending, Key: key})
+			pending = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if first {
+		return nil, fmt.Errorf("empty playlist")
+	}
+
+	return playlist, nil
+}
+
+// Attributes parses an attribute list such as `URI="init.mp4",BYTERANGE="1@0"`.
+func Attributes(list string) map[string]string {
+	attrs := map[string]string{}
+	for len(list) > 0 {
+		eq := strings.IndexByte(list, '=')
+		if eq == -1 {
+			break
+		}
+		key := strings.TrimSpace(list[:eq])
+		list = list[eq+1:]
+
+		var value string
+		if strings.HasPrefix(list, `"`) {
+			end := strings.IndexByte(list[1:], '"')
+			if end == -1 {
+				value, list = list[1:], ""
+			} else {
+				value, list = list[1:end+1], list[end+2:]
+			}
+		} else if comma := strings.IndexByte(list, ','); comma != -1 {
+			value, list = list[:comma], list[comma:]
+		} else {
+			value, list = list, ""
+		}
+		list = strings.TrimPrefix(list, ",")
+		attrs[key] = value
+	}
+
+	return attrs
+}
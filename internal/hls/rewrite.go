@@ -0,0 +1,32 @@
+package hls
+
+import (
+	"regexp"
+	"strings"
+)
+
+// uriAttribute matches the URI attribute of EXT-X-MEDIA, EXT-X-MAP,
+// EXT-X-KEY and EXT-X-I-FRAME-STREAM-INF tags.
+var uriAttribute = regexp.MustCompile(`URI="([^"]*)"`)
+
+// RewriteURIs passes every URI in a master or media playlist through rewrite:
+// URI lines (variant streams, segments) and URI="..." attributes.
+func RewriteURIs(playlist []byte, rewrite func(uri string) string) []byte {
+	lines := strings.Split(string(playlist), "\n")
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "":
+		case strings.HasPrefix(trimmed, "#EXT"):
+			lines[i] = uriAttribute.ReplaceAllStringFunc(line, func(match string) string {
+				uri := uriAttribute.FindStringSubmatch(match)[1]
+				return `URI="` + rewrite(uri) + `"`
+			})
+		case strings.HasPrefix(trimmed, "#"):
+		default:
+			lines[i] = rewrite(trimmed)
+		}
+	}
+
+	return []byte(strings.Join(lines, "\n"))
+}
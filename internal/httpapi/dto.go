@@ -1,10 +1,70 @@
 package httpapi
 
+import (
+	"time"
+
+	"upload/internal/collection"
+	"upload/internal/meta"
+)
+
 type UploadResponse struct {
 	ID     string `json:"id"`
 	Status string `json:"status"`
 }
 
+// PlaybackResponse lists the URLs a player needs; signed when URL signing
+// is on.
+type PlaybackResponse struct {
+	HLS       string            `json:"hls"`
+	DASH      string            `json:"dash,omitempty"`
+	Downloads map[string]string `json:"downloads,omitempty"` // by height
+	Sprites   string            `json:"sprites,omitempty"`   // WebVTT thumbnail track
+	Frames    string            `json:"frames"`              // add the t (and w, format) query parameters
+	ExpiresAt time.Time         `json:"expires_at,omitzero"`
+}
+
+// ThumbnailsResponse lists a video's stills and its poster; URLs are
+// signed when URL signing is on.
+type ThumbnailsResponse struct {
+	Images    []ThumbnailImage `json:"images"`
+	Poster    *ThumbnailImage  `json:"poster,omitempty"`
+	ExpiresAt time.Time        `json:"expires_at,omitzero"`
+}
+
+type ThumbnailImage struct {
+	URL          string  `json:"url"`
+	TimestampSec float64 `json:"timestamp_sec"`
+	Width        int     `json:"width"`
+	Height       int     `json:"height"`
+}
+
+// SearchResponse is one page of GET /search results, best match first.
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	Total   int            `json:"total"`
+	Offset  int            `json:"offset"`
+	Limit   int            `json:"limit"`
+	Results []SearchResult `json:"results"`
+}
+
+// SearchResult is a matching video. Highlights are HTML-escaped excerpts
+// per field with the matched words wrapped in <mark>.
+type SearchResult struct {
+	ID         string            `json:"id"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+	Video      meta.Metadata     `json:"video"`
+}
+
+// CollectionResponse is a collection with its playback URLs, signed when
+// URL signing is on, and the metadata of its videos in order.
+type CollectionResponse struct {
+	collection.Collection
+	CoverURL    string          `json:"cover_url,omitempty"`
+	PlaylistURL string          `json:"playlist_url"`
+	Videos      []meta.Metadata `json:"videos,omitempty"`
+}
+
 type ErrorResponse struct {
 	Error string `json:"error"`
 }
@@ -4,3 +4,13 @@ import "github.com/google/uuid"
 
 // New returns a new UUIDv4 string.
 func New() string { return uuid.NewString() }
+
+// Valid reports whether s is a canonical UUID string, so it is safe to use as
+// a path component.
+func Valid(s string) bool {
+	if len(s) != 36 {
+		return false
+	}
+	_, err := uuid.Parse(s)
+	return err == nil
+}
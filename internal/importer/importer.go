@@ -0,0 +1,400 @@
+package importer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"upload/internal/config"
+	"upload/internal/fsutil"
+	"upload/internal/id"
+	"upload/internal/ingest"
+	"upload/internal/meta"
+	"upload/internal/store"
+)
+
+// ErrSourceNotAllowed is returned when the URL host or local path is outside
+// the configured allowlists.
+var ErrSourceNotAllowed = errors.New("import source not allowed")
+
+// ErrInvalidSource is returned for malformed requests.
+var ErrInvalidSource = errors.New("invalid import source")
+
+type Request struct {
+	URL      string `json:"url,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Filename string `json:"filename,omitempty"` // optional override for the stored name
+	Owner    string `json:"-"`                  // authenticated caller, set by the server
+	Tenant   string `json:"-"`                  // caller's tenant, set by the server
+	Quota    int64  `json:"-"`                  // bytes the tenant may still store; <= 0 is unlimited
+	Release  func() `json:"-"`                  // returns the quota reservation once the import is over
+}
+
+type Importer struct {
+	config config.Config
+	store  meta.Store
+	client *http.Client
+	onDone func(videoID string)
+}
+
+// NewImporter returns an importer that calls onDone once an original has been
+// fetched and the video is queued for processing.
+func NewImporter(config config.Config, store meta.Store, onDone func(videoID string)) *Importer {
+	importer := &Importer{
+		config: config,
+		store:  meta.KeepDetails(store),
+		onDone: onDone,
+	}
+	importer.client = &http.Client{
+		Timeout: config.ImportTimeout,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 5 {
+				return errors.New("too many redirects")
+			}
+			// a redirect must not escape the allowlist
+			if !importer.hostAllowed(req.URL.Hostname()) {
+				return ErrSourceNotAllowed
+			}
+			return nil
+		},
+	}
+
+	return importer
+}
+
+// Start validates the request, records an "importing" metadata entry and
+// fetches the source in the background. request.Release is called when the
+// fetch ends, or right away if the import doesn't start.
+func (importer *Importer) Start(request Request) (meta.Metadata, error) {
+	started := false
+	defer func() {
+		if !started {
+			request.release()
+		}
+	}()
+
+	source, filename, err := importer.resolve(request)
+	if err != nil {
+		return meta.Metadata{}, err
+	}
+
+	vid := id.New()
+	m := meta.Metadata{
+		ID:               vid,
+		OriginalFilename: filename,
+		MIME:             ingest.DetectMIME("", filepath.Ext(filename)),
+		Status:           string(store.StatusImporting),
+		Tenant:           request.Tenant,
+		Owner:            request.Owner,
+		StorageBase:      fsutil.TenantRoot(importer.config.StorageDir, request.Tenant),
+		Variants:         []meta.Variant{},
+		Import: &meta.ImportInfo{
+			Source:    source,
+			StartedAt: time.Now(),
+		},
+	}
+	if err := importer.store.Create(m); err != nil {
+		return meta.Metadata{}, fmt.Errorf("create metadata: %w", err)
+	}
+
+	started = true
+	go importer.run(vid, request)
+
+	return m, nil
+}
+
+func (request Request) release() {
+	if request.Release != nil {
+		request.Release()
+	}
+}
+
+// resolve checks the source against the allowlists and derives a filename.
+func (importer *Importer) resolve(request Request) (source string, filename string, err error) {
+	switch {
+	case request.URL != "" && request.Path != "":
+		return "", "", fmt.Errorf("%w: set either url or path, not both", ErrInvalidSource)
+	case request.URL != "":
+		u, err := url.Parse(request.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", "", fmt.Errorf("%w: url must be http or https", ErrInvalidSource)
+		}
+		if !importer.hostAllowed(u.Hostname()) {
+			return "", "", fmt.Errorf("%w: host %s", ErrSourceNotAllowed, u.Hostname())
+		}
+		source, filename = u.String(), path.Base(u.Path)
+	case request.Path != "":
+		p, err := importer.localPath(request.Path)
+		if err != nil {
+			return "", "", err
+		}
+		source, filename = p, filepath.Base(p)
+	default:
+		return "", "", fmt.Errorf("%w: url or path is required", ErrInvalidSource)
+	}
+
+	if request.Filename != "" {
+		filename = filepath.Base(request.Filename)
+	}
+	if filename == "" || filename == "." || filename == "/" {
+		filename = "original.mp4"
+	}
+	if !ingest.IsAllowedExtension(filename) {
+		return "", "", fmt.Errorf("%w: unsupported file extension %q", ErrInvalidSource, filepath.Ext(filename))
+	}
+
+	return source, filename, nil
+}
+
+func (importer *Importer) hostAllowed(host string) bool {
+	host = strings.ToLower(host)
+	for _, allowed := range importer.config.ImportAllowedHosts {
+		allowed = strings.ToLower(allowed)
+		// ".example.com" allows any subdomain of example.com
+		if strings.HasPrefix(allowed, ".") {
+			if strings.HasSuffix(host, allowed) {
+				return true
+			}
+			continue
+		}
+		if host == allowed {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localPath makes sure the file sits inside one of the allowlisted
+// directories, both as written and with symlinks resolved. Paths outside the
+// allowlist are never touched, and every failure looks the same so the
+// response doesn't reveal what exists on the host.
+func (importer *Importer) localPath(p string) (string, error) {
+	notAllowed := fmt.Errorf("%w: path %s", ErrSourceNotAllowed, p)
+
+	cleaned, err := filepath.Abs(p)
+	if err != nil || !importer.dirAllowed(cleaned, false) {
+		return "", notAllowed
+	}
+	resolved, err := filepath.EvalSymlinks(cleaned)
+	if err != nil || !importer.dirAllowed(resolved, true) {
+		return "", notAllowed
+	}
+	info, err := os.Stat(resolved)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", notAllowed
+	}
+
+	return resolved, nil
+}
+
+// dirAllowed reports whether p lies inside an allowlisted directory, taken
+// as configured or, with resolve, with its symlinks resolved.
+func (importer *Importer) dirAllowed(p string, resolve bool) bool {
+	for _, dir := range importer.config.ImportAllowedDirs {
+		base, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if resolve {
+			if base, err = filepath.EvalSymlinks(base); err != nil {
+				continue
+			}
+		}
+		rel, err := filepath.Rel(base, p)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (importer *Importer) run(videoID string, request Request) {
+	defer request.release()
+	ctx, cancel := context.WithTimeout(context.Background(), importer.config.ImportTimeout)
+	defer cancel()
+
+	m, err := importer.store.Get(videoID)
+	if err != nil {
+		log.Printf("Import %s: get metadata: %v", videoID, err)
+		return
+	}
+
+	res, err := importer.fetch(ctx, cancel, &m, request)
+	if err != nil {
+		log.Printf("Import %s failed: %v", videoID, err)
+		os.RemoveAll(fsutil.OriginalsDir(m.StorageBase, videoID))
+		reason := err.Error()
+		if isTimeout(err) {
+			reason = fmt.Sprintf("timed out after %s", importer.config.ImportTimeout)
+		}
+		if errors.Is(err, ingest.ErrTooLarge) && request.Quota > 0 && importer.limit(request) == request.Quota {
+			reason = "storage quota exceeded"
+		}
+		m.Status = string(store.StatusFailed)
+		m.ErrorMessage = "import failed: " + reason
+		m.Import.Error = reason
+		m.Import.FinishedAt = time.Now()
+		if errors.Is(importer.save(m), errCanceled) {
+			log.Printf("Import %s canceled", videoID)
+		}
+		return
+	}
+
+	m.SizeBytes = res.SizeBytes
+	m.ChecksumSHA256 = res.ChecksumSHA256
+	m.Status = string(store.StatusQueued)
+	m.Import.BytesDone = res.SizeBytes
+	m.Import.FinishedAt = time.Now()
+	if err := importer.save(m); err != nil {
+		if errors.Is(err, errCanceled) {
+			log.Printf("Import %s canceled", videoID)
+			os.RemoveAll(fsutil.OriginalsDir(m.StorageBase, videoID))
+			return
+		}
+		log.Printf("Import %s: update metadata: %v", videoID, err)
+		return
+	}
+
+	log.Printf("Imported %s from %s (%d bytes)", videoID, m.Import.Source, res.SizeBytes)
+	if importer.onDone != nil {
+		importer.onDone(videoID)
+	}
+}
+
+func (importer *Importer) fetch(ctx context.Context, cancel context.CancelFunc, m *meta.Metadata, request Request) (ingest.Result, error) {
+	var body io.Reader
+
+	if request.URL != "" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, m.Import.Source, nil)
+		if err != nil {
+			return ingest.Result{}, err
+		}
+		resp, err := importer.client.Do(req)
+		if err != nil {
+			return ingest.Result{}, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return ingest.Result{}, fmt.Errorf("source responded %s", resp.Status)
+		}
+		if limit := importer.limit(request); limit > 0 && resp.ContentLength > limit {
+			return ingest.Result{}, ingest.ErrTooLarge
+		}
+		if resp.ContentLength > 0 {
+			m.Import.BytesTotal = resp.ContentLength
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != "" {
+			m.MIME = ingest.DetectMIME(ct, filepath.Ext(m.OriginalFilename))
+		}
+		body = resp.Body
+	} else {
+		f, err := os.Open(m.Import.Source)
+		if err != nil {
+			return ingest.Result{}, err
+		}
+		defer f.Close()
+		if info, err := f.Stat(); err == nil {
+			if limit := importer.limit(request); limit > 0 && info.Size() > limit {
+				return ingest.Result{}, ingest.ErrTooLarge
+			}
+			m.Import.BytesTotal = info.Size()
+		}
+		body = contextReader{ctx: ctx, r: f}
+	}
+
+	progress := &progressReader{r: body, report: func(done int64) {
+		m.Import.BytesDone = done
+		if errors.Is(importer.save(*m), errCanceled) {
+			cancel()
+		}
+	}}
+	dst := fsutil.OriginalPath(m.StorageBase, m.ID, m.OriginalFilename)
+
+	return ingest.Save(dst, progress, importer.limit(request))
+}
+
+var errCanceled = errors.New("import canceled")
+
+// save copies the fields the import owns from m onto the stored record. An
+// operator may cancel the import while it runs (`videoctl cancel`); then the
+// record is left alone and errCanceled is returned.
+func (importer *Importer) save(m meta.Metadata) error {
+	_, err := importer.store.Modify(m.ID, func(current *meta.Metadata) error {
+		if current.Status == string(store.StatusCanceled) {
+			return errCanceled
+		}
+		info := *m.Import
+		current.MIME = m.MIME
+		current.SizeBytes = m.SizeBytes
+		current.ChecksumSHA256 = m.ChecksumSHA256
+		current.Status = m.Status
+		current.ErrorMessage = m.ErrorMessage
+		current.Import = &info
+		return nil
+	})
+	return err
+}
+
+func (importer *Importer) maxBytes() int64 {
+	return int64(importer.config.ImportMaxMB) * 1024 * 1024
+}
+
+// limit is IMPORT_MAX_MB or what is left of the tenant's storage quota,
+// whichever is smaller.
+func (importer *Importer) limit(request Request) int64 {
+	limit := importer.maxBytes()
+	if request.Quota > 0 && (limit <= 0 || request.Quota < limit) {
+		return request.Quota
+	}
+	return limit
+}
+
+// progressReader reports bytes read at most once per second.
+type progressReader struct {
+	r      io.Reader
+	report func(done int64)
+	done   int64
+	last   time.Time
+}
+
+func (reader *progressReader) Read(p []byte) (int, error) {
+	n, err := reader.r.Read(p)
+	reader.done += int64(n)
+	if time.Since(reader.last) >= time.Second {
+		reader.last = time.Now()
+		reader.report(reader.done)
+	}
+
+	return n, err
+}
+
+// contextReader stops local copies once the import timeout expires.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (reader contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return reader.r.Read(p)
+}
+
+// isTimeout reports whether err came from the client or context deadline.
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout())
+}
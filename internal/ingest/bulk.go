@@ -0,0 +1,162 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Manifest entry statuses. Anything other than ManifestFailed is skipped when
+// a bulk ingest is resumed.
+const (
+	ManifestImported = "imported"
+	ManifestFailed   = "failed"
+)
+
+type ManifestEntry struct {
+	ID          string    `json:"id,omitempty"`
+	SizeBytes   int64     `json:"size_bytes,omitempty"`
+	Status      string    `json:"status"`
+	VideoStatus string    `json:"video_status,omitempty"` // processing result, filled after the queue drains
+	Error       string    `json:"error,omitempty"`
+	ImportedAt  time.Time `json:"imported_at,omitzero"`
+}
+
+// Manifest records which files of a directory have been ingested and under
+// which video ID, keyed by path relative to Root. It is rewritten after every
+// change so an interrupted run can be resumed.
+type Manifest struct {
+	Root    string                    `json:"root"`
+	Entries map[string]*ManifestEntry `json:"entries"`
+
+	path string
+	mu   sync.Mutex
+}
+
+func LoadManifest(path, root string) (*Manifest, error) {
+	manifest := &Manifest{Root: root, Entries: map[string]*ManifestEntry{}, path: path}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return manifest, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, manifest); err != nil {
+		return nil, err
+	}
+	if manifest.Entries == nil {
+		manifest.Entries = map[string]*ManifestEntry{}
+	}
+
+	return manifest, nil
+}
+
+// Done reports whether rel was already ingested by a previous run.
+func (manifest *Manifest) Done(rel string) bool {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+
+	entry, ok := manifest.Entries[rel]
+	return ok && entry.Status != ManifestFailed
+}
+
+func (manifest *Manifest) Entry(rel string) (ManifestEntry, bool) {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+
+	entry, ok := manifest.Entries[rel]
+	if !ok {
+		return ManifestEntry{}, false
+	}
+	return *entry, true
+}
+
+// Set stores the entry and persists the manifest.
+func (manifest *Manifest) Set(rel string, entry ManifestEntry) error {
+	manifest.mu.Lock()
+	defer manifest.mu.Unlock()
+
+	manifest.Entries[rel] = &entry
+	b, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := manifest.path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+	// On Windows, rename over existing fails; remove first.
+	_ = os.Remove(manifest.path)
+	return os.Rename(tmp, manifest.path)
+}
+
+// Walk returns the video files under root (relative, sorted) whose extension
+// passes IsAllowedExtension.
+func Walk(root string) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !IsAllowedExtension(d.Name()) {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		files = append(files, rel)
+		return nil
+	})
+	sort.Strings(files)
+
+	return files, err
+}
+
+// Place puts src at dst, either as a hard link or as a copy. Linking falls
+// back to copying when src lives on another filesystem.
+func Place(src, dst string, link bool) (Result, error) {
+	if link {
+		if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+			return Result{}, err
+		}
+		_ = os.Remove(dst)
+		if err := os.Link(src, dst); err == nil {
+			return hashFile(dst)
+		}
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+
+	return Save(dst, f, 0)
+}
+
+func hashFile(p string) (Result, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return Result{}, err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	n, err := io.Copy(hash, f)
+	if err != nil {
+		return Result{}, err
+	}
+
+	return Result{SizeBytes: n, ChecksumSHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
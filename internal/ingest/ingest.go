@@ -0,0 +1,132 @@
+package ingest
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrTooLarge is returned when the stream exceeds the configured size limit.
+var ErrTooLarge = errors.New("ingest: size limit exceeded")
+
+type Result struct {
+	SizeBytes      int64
+	ChecksumSHA256 string
+}
+
+// Save streams r straight into dest without any intermediate spooling.
+// Data is written to dest+".part" and renamed into place only once the whole
+// stream has been read, so a failed or oversized upload never leaves a
+// truncated original behind. A limit <= 0 disables the size check.
+func Save(dest string, r io.Reader, limit int64) (Result, error) {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return Result{}, err
+	}
+
+	tmp := dest + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return Result{}, err
+	}
+
+	src := r
+	if limit > 0 {
+		// read one byte past the limit so we can tell "exactly limit" from "too large"
+		src = io.LimitReader(r, limit+1)
+	}
+
+	hash := sha256.New()
+	n, cErr := io.Copy(io.MultiWriter(f, hash), src)
+	fErr := f.Close()
+	if cErr == nil && limit > 0 && n > limit {
+		cErr = ErrTooLarge
+	}
+	if cErr != nil || fErr != nil {
+		os.Remove(tmp)
+		if cErr != nil {
+			return Result{}, cErr
+		}
+		return Result{}, fErr
+	}
+
+	// On Windows, rename over existing fails; remove first.
+	_ = os.Remove(dest)
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return Result{}, err
+	}
+
+	return Result{SizeBytes: n, ChecksumSHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+var mimeByExt = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/mp4",
+	".mov":  "video/quicktime",
+	".avi":  "video/x-msvideo",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".wmv":  "video/x-ms-wmv",
+	".flv":  "video/x-flv",
+	".mpg":  "video/mpeg",
+	".mpeg": "video/mpeg",
+}
+
+// DetectMIME prefers the declared content type and falls back to the file
+// extension when the client sent nothing useful.
+func DetectMIME(contentType, ext string) string {
+	if index := strings.Index(contentType, ";"); index != -1 {
+		contentType = contentType[:index]
+	}
+	contentType = strings.TrimSpace(contentType)
+	if contentType != "" && contentType != "application/octet-stream" {
+		return contentType
+	}
+
+	if mimeType, ok := mimeByExt[strings.ToLower(ext)]; ok {
+		return mimeType
+	}
+
+	return "video/mp4" // default fallback
+}
+
+// ExtensionFor returns a file extension for a MIME type, used when the client
+// didn't send a filename (e.g. raw PUT bodies).
+func ExtensionFor(mimeType string) string {
+	switch strings.ToLower(mimeType) {
+	case "video/quicktime":
+		return ".mov"
+	case "video/x-msvideo":
+		return ".avi"
+	case "video/x-matroska":
+		return ".mkv"
+	case "video/webm":
+		return ".webm"
+	case "video/x-ms-wmv":
+		return ".wmv"
+	case "video/x-flv":
+		return ".flv"
+	case "video/mpeg":
+		return ".mpg"
+	}
+
+	return ".mp4"
+}
+
+var allowedExts = []string{".mp4", ".mov", ".mkv", ".avi", ".webm", ".m4v", ".mpg", ".mpeg", ".wmv", ".flv"}
+
+// IsAllowedExtension reports whether filename has a video extension we accept.
+func IsAllowedExtension(filename string) bool {
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, allowed := range allowedExts {
+		if ext == allowed {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,174 @@
+package meta
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Limits on user-editable metadata.
+const (
+	MaxTitleLength       = 200
+	MaxDescriptionLength = 5000
+	MaxTags              = 32
+	MaxTagLength         = 64
+	MaxCustomFields      = 32
+	MaxCustomKeyLength   = 64
+	MaxCustomValueLength = 1024
+)
+
+// Visibility values. Private videos are seen by their owner only, unlisted
+// ones by anyone in the tenant who has the id, public ones are also listed.
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+)
+
+var ErrInvalidDetails = errors.New("invalid metadata")
+
+// Details are the fields users edit through PATCH /videos/:id; everything
+// else in Metadata is maintained by the pipeline.
+type Details struct {
+	Title       string            `json:"title,omitempty"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Visibility  string            `json:"visibility,omitempty"` // "" is private
+	Custom      map[string]string `json:"custom,omitempty"`
+}
+
+// Edit is a partial update of Details: nil fields are left alone, and a
+// null custom value removes the key.
+type Edit struct {
+	Title       *string            `json:"title"`
+	Description *string            `json:"description"`
+	Tags        *[]string          `json:"tags"`
+	Visibility  *string            `json:"visibility"`
+	Custom      map[string]*string `json:"custom"`
+}
+
+// Apply validates the edit and applies it to details. Tags are trimmed,
+// lowercased and deduplicated. details is untouched on error.
+func (edit Edit) Apply(details *Details) error {
+	updated := *details
+	updated.Tags = slices.Clone(details.Tags)
+	updated.Custom = make(map[string]string, len(details.Custom))
+	for k, v := range details.Custom {
+		updated.Custom[k] = v
+	}
+
+	if edit.Title != nil {
+		updated.Title = strings.TrimSpace(*edit.Title)
+		if utf8.RuneCountInString(updated.Title) > MaxTitleLength {
+			return fmt.Errorf("%w: title is longer than %d characters", ErrInvalidDetails, MaxTitleLength)
+		}
+	}
+	if edit.Description != nil {
+		updated.Description = strings.TrimSpace(*edit.Description)
+		if utf8.RuneCountInString(updated.Description) > MaxDescriptionLength {
+			return fmt.Errorf("%w: description is longer than %d characters", ErrInvalidDetails, MaxDescriptionLength)
+		}
+	}
+	if edit.Tags != nil {
+		updated.Tags = []string{}
+		for _, tag := range *edit.Tags {
+			tag = strings.ToLower(strings.TrimSpace(tag))
+			if tag == "" || slices.Contains(updated.Tags, tag) {
+				continue
+			}
+			if utf8.RuneCountInString(tag) > MaxTagLength {
+				return fmt.Errorf("%w: tag %q is longer than %d characters", ErrInvalidDetails, tag, MaxTagLength)
+			}
+			updated.Tags = append(updated.Tags, tag)
+		}
+		if len(updated.Tags) > MaxTags {
+			return fmt.Errorf("%w: more than %d tags", ErrInvalidDetails, MaxTags)
+		}
+	}
+	if edit.Visibility != nil {
+		switch *edit.Visibility {
+		case VisibilityPrivate, VisibilityUnlisted, VisibilityPublic:
+			updated.Visibility = *edit.Visibility
+		default:
+			return fmt.Errorf("%w: visibility must be private, unlisted or public", ErrInvalidDetails)
+		}
+	}
+	for key, value := range edit.Custom {
+		if !validCustomKey(key) {
+			return fmt.Errorf("%w: custom key %q must be 1-%d letters, digits, '_', '-' or '.'", ErrInvalidDetails, key, MaxCustomKeyLength)
+		}
+		if value == nil {
+			delete(updated.Custom, key)
+			continue
+		}
+		if utf8.RuneCountInString(*value) > MaxCustomValueLength {
+			return fmt.Errorf("%w: custom value of %q is longer than %d characters", ErrInvalidDetails, key, MaxCustomValueLength)
+		}
+		updated.Custom[key] = *value
+	}
+	if len(updated.Custom) > MaxCustomFields {
+		return fmt.Errorf("%w: more than %d custom fields", ErrInvalidDetails, MaxCustomFields)
+	}
+	if len(updated.Custom) == 0 {
+		updated.Custom = nil
+	}
+	if len(updated.Tags) == 0 {
+		updated.Tags = nil
+	}
+
+	*details = updated
+	return nil
+}
+
+// IsPublic reports whether the video is listed for everyone in its tenant.
+func (details Details) IsPublic() bool {
+	return details.Visibility == VisibilityPublic
+}
+
+// Shared reports whether others in the tenant may open the video.
+func (details Details) Shared() bool {
+	return details.Visibility == VisibilityPublic || details.Visibility == VisibilityUnlisted
+}
+
+func validCustomKey(key string) bool {
+	if key == "" || len(key) > MaxCustomKeyLength {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// KeepDetails wraps the store of a long-running job (processing, imports)
+// that updates its own copy of a record: every Update keeps the Details
+// currently stored, so edits made meanwhile aren't overwritten.
+func KeepDetails(store Store) Store {
+	return &detailsKeepingStore{Store: store}
+}
+
+type detailsKeepingStore struct {
+	Store
+}
+
+func (s *detailsKeepingStore) Update(m Metadata) error {
+	if current, err := s.Store.Get(m.ID); err == nil {
+		m.Details = current.Details
+	}
+	return s.Store.Update(m)
+}
+
+func (s *detailsKeepingStore) Modify(id string, fn func(m *Metadata) error) (Metadata, error) {
+	return s.Store.Modify(id, func(m *Metadata) error {
+		details := m.Details
+		if err := fn(m); err != nil {
+			return err
+		}
+		m.Details = details
+		return nil
+	})
+}
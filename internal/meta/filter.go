@@ -0,0 +1,49 @@
+package meta
+
+import (
+	"slices"
+	"strings"
+)
+
+// Filter selects videos by their details and status. Empty fields match
+// everything.
+type Filter struct {
+	Query      string            // case-insensitive substring of the title or description
+	Tags       []string          // every tag must be present
+	Status     string            // queued, processing, ready, ...
+	Visibility string            // "private" also matches videos that never set one
+	Custom     map[string]string // exact custom field values
+}
+
+func (filter Filter) Match(m Metadata) bool {
+	if filter.Status != "" && m.Status != filter.Status {
+		return false
+	}
+	if filter.Visibility != "" {
+		visibility := m.Visibility
+		if visibility == "" {
+			visibility = VisibilityPrivate
+		}
+		if visibility != filter.Visibility {
+			return false
+		}
+	}
+	for _, tag := range filter.Tags {
+		if !slices.Contains(m.Tags, strings.ToLower(tag)) {
+			return false
+		}
+	}
+	for key, value := range filter.Custom {
+		if actual, ok := m.Custom[key]; !ok || actual != value {
+			return false
+		}
+	}
+	if filter.Query != "" {
+		query := strings.ToLower(filter.Query)
+		if !strings.Contains(strings.ToLower(m.Title), query) && !strings.Contains(strings.ToLower(m.Description), query) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -7,11 +7,14 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 )
 
 type JSONStore struct {
 	root string
+	mu   sync.Mutex // serializes writes so Modify sees the latest record
 }
 
 func NewJSONStore(root string) *JSONStore {
@@ -22,12 +25,15 @@ func (s *JSONStore) pathFor(id string) string {
 	return filepath.Join(s.root, fmt.Sprintf("%s.json", id))
 }
 
+// Create fails with fs.ErrExist when a record with the same ID is stored.
 func (s *JSONStore) Create(m Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	now := time.Now()
 	m.CreatedAt = now
 	m.UpdatedAt = now
 	p := s.pathFor(m.ID)
-	if err := writeFileAtomic(p, m); err != nil {
+	if err := createFileExclusive(p, m); err != nil {
 		return err
 	}
 	return nil
@@ -50,11 +56,31 @@ func (s *JSONStore) Get(id string) (Metadata, error) {
 }
 
 func (s *JSONStore) Update(m Metadata) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	m.UpdatedAt = time.Now()
 	p := s.pathFor(m.ID)
 	return writeFileAtomic(p, m)
 }
 
+func (s *JSONStore) Modify(id string, fn func(m *Metadata) error) (Metadata, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m, err := s.Get(id)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if err := fn(&m); err != nil {
+		return Metadata{}, err
+	}
+	m.ID = id
+	m.UpdatedAt = time.Now()
+	if err := writeFileAtomic(s.pathFor(id), m); err != nil {
+		return Metadata{}, err
+	}
+	return m, nil
+}
+
 func (s *JSONStore) List() ([]Metadata, error) {
 	entries, err := os.ReadDir(s.root)
 	if err != nil {
@@ -77,6 +103,68 @@ func (s *JSONStore) List() ([]Metadata, error) {
 	return out, nil
 }
 
+// Modified returns the modification time of every stored record by ID, so
+// readers can notice writes made by another process (e.g. videoctl).
+func (s *JSONStore) Modified() (map[string]time.Time, error) {
+	entries, err := os.ReadDir(s.root)
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]time.Time, len(entries))
+	for _, e := range entries {
+		if e.IsDir() || filepath.Ext(e.Name()) != ".json" {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		out[strings.TrimSuffix(e.Name(), ".json")] = info.ModTime()
+	}
+	return out, nil
+}
+
+func (s *JSONStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	err := os.Remove(s.pathFor(id))
+	if errors.Is(err, fs.ErrNotExist) {
+		return fs.ErrNotExist
+	}
+	return err
+}
+
+// createFileExclusive writes JSON to a temp file and links it into place,
+// which fails if dest exists.
+func createFileExclusive(dest string, v any) error {
+	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), filepath.Base(dest)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.Write(b)
+	if cErr := f.Close(); err == nil {
+		err = cErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Link(f.Name(), dest); err != nil {
+		if errors.Is(err, fs.ErrExist) {
+			return fs.ErrExist
+		}
+		return err
+	}
+	return nil
+}
+
 // writeFileAtomic writes JSON to a temp file then renames it into place.
 func writeFileAtomic(dest string, v any) error {
 	if err := os.MkdirAll(filepath.Dir(dest), 0o755); err != nil {
@@ -0,0 +1,72 @@
+package meta
+
+import "io/fs"
+
+// ScopedStore confines a Store to one tenant: videos of other tenants look
+// like they don't exist, and new videos are created in the tenant.
+type ScopedStore struct {
+	Store
+	tenant string
+}
+
+func NewScopedStore(store Store, tenant string) *ScopedStore {
+	return &ScopedStore{Store: store, tenant: tenant}
+}
+
+func (s *ScopedStore) Create(m Metadata) error {
+	m.Tenant = s.tenant
+	return s.Store.Create(m)
+}
+
+func (s *ScopedStore) Get(id string) (Metadata, error) {
+	m, err := s.Store.Get(id)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if m.Tenant != s.tenant {
+		return Metadata{}, fs.ErrNotExist
+	}
+	return m, nil
+}
+
+func (s *ScopedStore) Update(m Metadata) error {
+	if _, err := s.Get(m.ID); err != nil {
+		return err
+	}
+	m.Tenant = s.tenant
+	return s.Store.Update(m)
+}
+
+func (s *ScopedStore) Modify(id string, fn func(m *Metadata) error) (Metadata, error) {
+	return s.Store.Modify(id, func(m *Metadata) error {
+		if m.Tenant != s.tenant {
+			return fs.ErrNotExist
+		}
+		if err := fn(m); err != nil {
+			return err
+		}
+		m.Tenant = s.tenant
+		return nil
+	})
+}
+
+func (s *ScopedStore) List() ([]Metadata, error) {
+	videos, err := s.Store.List()
+	if err != nil {
+		return nil, err
+	}
+	out := videos[:0]
+	for _, m := range videos {
+		if m.Tenant == s.tenant {
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+func (s *ScopedStore) Delete(id string) error {
+	if _, err := s.Get(id); err != nil {
+		return err
+	}
+	return s.Store.Delete(id)
+}
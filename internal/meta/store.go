@@ -5,5 +5,9 @@ type Store interface {
 	Create(m Metadata) error
 	Get(id string) (Metadata, error)
 	Update(m Metadata) error
+	// Modify applies fn to the current record of id and writes it back
+	// without interleaving with other writes; fn's error aborts the write.
+	Modify(id string, fn func(m *Metadata) error) (Metadata, error)
 	List() ([]Metadata, error)
+	Delete(id string) error
 }
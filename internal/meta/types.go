@@ -3,28 +3,144 @@ package meta
 import "time"
 
 type Variant struct {
-	Format      string `json:"format"` // e.g., hls, mp4
-	Height      int    `json:"height"` // 480, 720, 1080
+	Format      string `json:"format"`              // e.g., hls, mp4
+	Container   string `json:"container,omitempty"` // hls segments: ts or fmp4
+	Codec       string `json:"codec,omitempty"`     // h264, hevc, vp9, av1
+	Height      int    `json:"height"`              // 480, 720, 1080
 	BitrateKbps int    `json:"bitrate_kbps"`
 	PathOrPl    string `json:"path_or_playlist"`
 	SizeBytes   int64  `json:"size_bytes"`
 	ReadyAtUnix int64  `json:"ready_at,omitempty"`
+
+	// Measured from the encoded output
+	Width            int     `json:"width,omitempty"`
+	EncodedHeight    int     `json:"encoded_height,omitempty"`
+	Codecs           string  `json:"codecs,omitempty"`            // RFC 6381, e.g. avc1.4D401F,mp4a.40.2
+	BandwidthPeak    int     `json:"bandwidth,omitempty"`         // bits/s, highest segment bitrate
+	BandwidthAverage int     `json:"average_bandwidth,omitempty"` // bits/s
+	FrameRate        float64 `json:"frame_rate,omitempty"`
+
+	// Separate audio and subtitle renditions (Kind "audio", "subtitles") are
+	// listed as EXT-X-MEDIA entries of Group instead of as variant streams.
+	Kind     string `json:"kind,omitempty"` // "" for video, "audio", "subtitles"
+	Group    string `json:"group,omitempty"`
+	Name     string `json:"name,omitempty"`
+	Language string `json:"language,omitempty"` // RFC 5646, e.g. "en"
+	Channels int    `json:"channels,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+}
+
+// AudioTrack is an audio stream found in the original.
+type AudioTrack struct {
+	Index    int    `json:"index"` // ffmpeg 0:a:N
+	Codec    string `json:"codec"`
+	Channels int    `json:"channels,omitempty"`
+	Language string `json:"language,omitempty"` // as tagged in the source, e.g. "eng"
+	Title    string `json:"title,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+}
+
+// SubtitleTrack is a caption track: a text subtitle stream embedded in the
+// original, or a file uploaded through POST /videos/:id/subtitles.
+type SubtitleTrack struct {
+	Source   string `json:"source"`          // embedded, upload
+	Index    int    `json:"index,omitempty"` // ffmpeg 0:s:N for embedded tracks
+	Codec    string `json:"codec,omitempty"`
+	Language string `json:"language,omitempty"` // RFC 5646, e.g. "en"
+	Name     string `json:"name,omitempty"`
+	Default  bool   `json:"default,omitempty"`
+	Forced   bool   `json:"forced,omitempty"`
+}
+
+// Portrait reports whether the video is displayed taller than wide.
+func (m Metadata) Portrait() bool {
+	return m.Height > m.Width
+}
+
+// ShortSide is the displayed short edge, which ladder heights refer to.
+func (m Metadata) ShortSide() int {
+	if m.Width == 0 {
+		return m.Height
+	}
+	return min(m.Width, m.Height)
+}
+
+// Thumbnail is a still image of the video.
+type Thumbnail struct {
+	Path      string  `json:"path"`          // thumbnails/<id>/thumb_001.jpg
+	Timestamp float64 `json:"timestamp_sec"` // position in the video
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+}
+
+// Thumbnails are the evenly spaced stills plus the designated poster.
+type Thumbnails struct {
+	Images []Thumbnail `json:"images"`
+	Poster *Thumbnail  `json:"poster,omitempty"`
+}
+
+// SpriteSheets is the scrub-bar preview track: tiled JPEG sheets plus a
+// WebVTT file whose cues point at #xywh regions of them.
+type SpriteSheets struct {
+	VTT        string   `json:"vtt"`    // thumbnails/<id>/sprites.vtt
+	Images     []string `json:"images"` // thumbnails/<id>/sprite_NNN.jpg
+	Interval   float64  `json:"interval_sec"`
+	TileWidth  int      `json:"tile_width"`
+	TileHeight int      `json:"tile_height"`
+	Columns    int      `json:"columns"`
+	Rows       int      `json:"rows"`
+}
+
+// AnimatedPreview is a short silent teaser joined from snippets of the
+// video, for hover previews.
+type AnimatedPreview struct {
+	WebP        string    `json:"webp"` // thumbnails/<id>/preview.webp
+	MP4         string    `json:"mp4"`  // thumbnails/<id>/preview.mp4
+	WebPBytes   int64     `json:"webp_bytes"`
+	MP4Bytes    int64     `json:"mp4_bytes"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	DurationSec float64   `json:"duration_sec"`
+	Snippets    []float64 `json:"snippets"` // start of each snippet in the video, seconds
+}
+
+// ImportInfo tracks a server-side fetch from a URL or local path.
+type ImportInfo struct {
+	Source     string    `json:"source"`
+	BytesDone  int64     `json:"bytes_done"`
+	BytesTotal int64     `json:"bytes_total,omitempty"` // 0 when the source didn't report a size
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at,omitzero"`
+	Error      string    `json:"error,omitempty"`
 }
 
 type Metadata struct {
-	ID               string    `json:"id"`
-	OriginalFilename string    `json:"original_filename"`
-	MIME             string    `json:"mime"`
-	SizeBytes        int64     `json:"size_bytes"`
-	ChecksumSHA256   string    `json:"checksum_sha256"`
-	Status           string    `json:"status"` // queued, processing, ready, failed
-	ErrorMessage     string    `json:"error_message,omitempty"`
-	DurationSec      float64   `json:"duration_sec,omitempty"`
-	Width            int       `json:"width,omitempty"`
-	Height           int       `json:"height,omitempty"`
-	FPS              float64   `json:"fps,omitempty"`
-	StorageBase      string    `json:"storage_base"`
-	Variants         []Variant `json:"variants"`
-	CreatedAt        time.Time `json:"created_at"`
-	UpdatedAt        time.Time `json:"updated_at"`
+	ID               string `json:"id"`
+	OriginalFilename string `json:"original_filename"`
+	MIME             string `json:"mime"`
+	SizeBytes        int64  `json:"size_bytes"`
+	ChecksumSHA256   string `json:"checksum_sha256"`
+	Tenant           string `json:"tenant,omitempty"` // "" is the default tenant
+	Owner            string `json:"owner,omitempty"`  // principal that uploaded the video
+	Details
+	Status         string           `json:"status"` // importing, queued, processing, ready, failed, canceled
+	ErrorMessage   string           `json:"error_message,omitempty"`
+	DurationSec    float64          `json:"duration_sec,omitempty"`
+	Width          int              `json:"width,omitempty"`    // as displayed, after rotation
+	Height         int              `json:"height,omitempty"`   // as displayed, after rotation
+	Rotation       int              `json:"rotation,omitempty"` // clockwise degrees applied on display
+	FPS            float64          `json:"fps,omitempty"`
+	AudioCodec     string           `json:"audio_codec,omitempty"` // empty when the source has no audio
+	AudioTracks    []AudioTrack     `json:"audio_tracks,omitempty"`
+	SubtitleTracks []SubtitleTrack  `json:"subtitle_tracks,omitempty"`
+	Encryption     string           `json:"encryption,omitempty"` // aes-128 when segments are encrypted
+	StorageBase    string           `json:"storage_base"`
+	Variants       []Variant        `json:"variants"`
+	Thumbnails     *Thumbnails      `json:"thumbnails,omitempty"`
+	Sprites        *SpriteSheets    `json:"sprites,omitempty"`
+	Preview        *AnimatedPreview `json:"preview,omitempty"`
+	Import         *ImportInfo      `json:"import,omitempty"`
+	CreatedAt      time.Time        `json:"created_at"`
+	UpdatedAt      time.Time        `json:"updated_at"`
 }
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"upload/internal/auth"
+)
+
+// PrincipalKey is the echo context key holding the authenticated
+// *auth.Principal.
+const PrincipalKey = "principal"
+
+type Auth struct {
+	authenticator *auth.Authenticator
+}
+
+// NewAuth wraps authenticator; a nil authenticator leaves every route open.
+func NewAuth(authenticator *auth.Authenticator) *Auth {
+	return &Auth{authenticator: authenticator}
+}
+
+// Require authenticates the request and checks that the caller was granted
+// scope.
+func (a *Auth) Require(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			if a.authenticator == nil {
+				return next(context)
+			}
+
+			principal, err := a.authenticator.Authenticate(context.Request())
+			if err != nil {
+				if errors.Is(err, auth.ErrNoCredentials) {
+					context.Response().Header().Set("WWW-Authenticate", `Bearer realm="videos"`)
+				} else {
+					context.Response().Header().Set("WWW-Authenticate", `Bearer realm="videos", error="invalid_token"`)
+				}
+				return context.JSON(http.StatusUnauthorized, map[string]string{"error": err.Error()})
+			}
+			if !principal.Has(scope) {
+				context.Response().Header().Set("WWW-Authenticate", `Bearer realm="videos", error="insufficient_scope", scope="`+scope+`"`)
+				return context.JSON(http.StatusForbidden, map[string]string{"error": "missing scope " + scope})
+			}
+			context.Set(PrincipalKey, principal)
+
+			return next(context)
+		}
+	}
+}
+
+// CurrentPrincipal returns the caller, or nil when authentication is off.
+func CurrentPrincipal(context echo.Context) *auth.Principal {
+	principal, _ := context.Get(PrincipalKey).(*auth.Principal)
+	return principal
+}
@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"upload/internal/signing"
+)
+
+// GrantKey is the echo context key holding the verified signing.Grant, so
+// handlers can sign the URLs they hand out (e.g. inside playlists) with the
+// same expiry and client binding.
+const GrantKey = "signed_grant"
+
+type SignedURLs struct {
+	signer *signing.Signer
+}
+
+func NewSignedURLs(signer *signing.Signer) *SignedURLs {
+	return &SignedURLs{signer: signer}
+}
+
+// Require rejects requests whose URL doesn't carry a valid signature for
+// its path.
+func (signed *SignedURLs) Require() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(context echo.Context) error {
+			request := context.Request()
+			grant, err := signed.signer.Verify(request.URL.Path, request.URL.Query(), context.RealIP())
+			if err != nil {
+				status := http.StatusForbidden
+				if errors.Is(err, signing.ErrMissing) {
+					status = http.StatusUnauthorized
+				}
+				return context.JSON(status, map[string]string{"error": err.Error()})
+			}
+			context.Set(GrantKey, grant)
+
+			return next(context)
+		}
+	}
+}
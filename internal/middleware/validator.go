@@ -4,8 +4,8 @@ import (
 	"fmt"
 	"mime/multipart"
 	"net/http"
-	"path/filepath"
 	"strings"
+	"upload/internal/ingest"
 	"upload/internal/store/config"
 
 	"github.com/labstack/echo/v4"
@@ -95,16 +95,7 @@ func (validator *Validator) isAllowedMIME(file *multipart.FileHeader) bool {
 }
 
 func (validator *Validator) isAllowedExtension(filename string) bool {
-	ext := strings.ToLower(filepath.Ext(filename))
-	allowedExts := []string{".mp4", ".mov", ".mkv", ".avi", ".webm", ".m4v", ".mpg", ".mpeg", ".wmv", ".flv"}
-
-	for _, allowed := range allowedExts {
-		if ext == allowed {
-			return true
-		}
-	}
-
-	return false
+	return ingest.IsAllowedExtension(filename)
 }
 
 func (validator *Validator) RateLimiter(requestsPerMinute int) echo.MiddlewareFunc {
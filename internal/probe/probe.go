@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"math"
 	"strconv"
 	"strings"
 	"upload/internal/config"
@@ -16,24 +17,96 @@ type Prober struct {
 }
 
 type VideoInfo struct {
-	Duration   float64
-	Width      int
-	Height     int
-	FPS        float64
-	Bitrate    int
-	CodecName  string
-	AudioCodec string
+	Duration     float64
+	Width        int
+	Height       int
+	FPS          float64
+	Bitrate      int
+	CodecName    string
+	Profile      string // e.g. "Main", "High"
+	Level        int    // e.g. 31 for 3.1
+	AudioCodec   string
+	AudioProfile string // e.g. "LC"
+
+	// AudioStreams lists every audio track in stream order; AudioCodec and
+	// AudioProfile describe the first one.
+	AudioStreams []AudioStream
+
+	// SubtitleStreams lists every subtitle track, text or bitmap.
+	SubtitleStreams []SubtitleStream
+
+	// Rotation is the clockwise display rotation (0, 90, 180 or 270) from the
+	// display matrix or the legacy rotate tag. DisplayWidth/DisplayHeight are
+	// the dimensions as shown to the viewer, i.e. Width/Height swapped for
+	// 90 and 270.
+	Rotation      int
+	DisplayWidth  int
+	DisplayHeight int
+}
+
+type AudioStream struct {
+	Index    int // position among the audio streams (ffmpeg's 0:a:N)
+	Codec    string
+	Profile  string
+	Channels int
+	Language string // ISO 639-2 tag as stored in the container, e.g. "eng"
+	Title    string
+	Default  bool
+}
+
+type SubtitleStream struct {
+	Index    int    // position among the subtitle streams (ffmpeg's 0:s:N)
+	Codec    string // subrip, ass, mov_text, webvtt, hdmv_pgs_subtitle, ...
+	Language string
+	Title    string
+	Default  bool
+	Forced   bool
+}
+
+// textSubtitleCodecs can be converted to WebVTT; bitmap formats (PGS,
+// VobSub, DVB) would need OCR.
+var textSubtitleCodecs = map[string]bool{
+	"subrip": true, "srt": true, "ass": true, "ssa": true,
+	"mov_text": true, "webvtt": true, "text": true,
+}
+
+// Text reports whether the stream can be converted to WebVTT.
+func (stream SubtitleStream) Text() bool {
+	return textSubtitleCodecs[stream.Codec]
+}
+
+// Portrait reports whether the video is taller than wide when displayed.
+func (info *VideoInfo) Portrait() bool {
+	return info.DisplayHeight > info.DisplayWidth
+}
+
+// ShortSide is the displayed short edge, which is what ladder heights refer to.
+func (info *VideoInfo) ShortSide() int {
+	return min(info.DisplayWidth, info.DisplayHeight)
 }
 
 type ffprobeOutput struct {
 	Streams []struct {
 		CodecType  string `json:"codec_type"`
 		CodecName  string `json:"codec_name"`
+		Profile    string `json:"profile,omitempty"`
+		Level      int    `json:"level,omitempty"`
 		Width      int    `json:"width,omitempty"`
 		Height     int    `json:"height,omitempty"`
 		RFrameRate string `json:"r_frame_rate,omitempty"`
 		BitRate    string `json:"bit_rate,omitempty"`
 		Duration   string `json:"duration,omitempty"`
+		Channels   int    `json:"channels,omitempty"`
+		Tags       struct {
+			Rotate   string `json:"rotate,omitempty"`
+			Language string `json:"language,omitempty"`
+			Title    string `json:"title,omitempty"`
+		} `json:"tags"`
+		Disposition struct {
+			Default int `json:"default"`
+			Forced  int `json:"forced"`
+		} `json:"disposition"`
+		SideDataList []sideData `json:"side_data_list,omitempty"`
 	} `json:"streams"`
 	Format struct {
 		Duration string `json:"duration"`
@@ -41,6 +114,11 @@ type ffprobeOutput struct {
 	} `json:"format"`
 }
 
+type sideData struct {
+	SideDataType string  `json:"side_data_type"`
+	Rotation     float64 `json:"rotation"`
+}
+
 func NewProber(config config.Config, runner exec.Runner) *Prober {
 	return &Prober{
 		config: config,
@@ -89,6 +167,9 @@ func (prober *Prober) ProbeVideo(context context.Context, videoPath string) (*Vi
 			info.Width = stream.Width
 			info.Height = stream.Height
 			info.CodecName = stream.CodecName
+			info.Profile = stream.Profile
+			info.Level = stream.Level
+			info.Rotation = streamRotation(stream.Tags.Rotate, stream.SideDataList)
 
 			// Parse FPS
 			if stream.RFrameRate != "" {
@@ -108,10 +189,61 @@ func (prober *Prober) ProbeVideo(context context.Context, videoPath string) (*Vi
 					info.Duration = duration
 				}
 			}
-		} else if stream.CodecType == "audio" && info.AudioCodec == "" {
-			info.AudioCodec = stream.CodecName
+		} else if stream.CodecType == "audio" {
+			if info.AudioCodec == "" {
+				info.AudioCodec = stream.CodecName
+				info.AudioProfile = stream.Profile
+			}
+			info.AudioStreams = append(info.AudioStreams, AudioStream{
+				Index:    len(info.AudioStreams),
+				Codec:    stream.CodecName,
+				Profile:  stream.Profile,
+				Channels: stream.Channels,
+				Language: stream.Tags.Language,
+				Title:    stream.Tags.Title,
+				Default:  stream.Disposition.Default == 1,
+			})
+		} else if stream.CodecType == "subtitle" {
+			info.SubtitleStreams = append(info.SubtitleStreams, SubtitleStream{
+				Index:    len(info.SubtitleStreams),
+				Codec:    stream.CodecName,
+				Language: stream.Tags.Language,
+				Title:    stream.Tags.Title,
+				Default:  stream.Disposition.Default == 1,
+				Forced:   stream.Disposition.Forced == 1,
+			})
 		}
 	}
 
+	info.DisplayWidth, info.DisplayHeight = info.Width, info.Height
+	if info.Rotation == 90 || info.Rotation == 270 {
+		info.DisplayWidth, info.DisplayHeight = info.Height, info.Width
+	}
+
 	return info, nil
 }
+
+// streamRotation normalizes the rotation to a clockwise angle in [0, 360).
+// ffprobe reports the display matrix rotation counter-clockwise (e.g. -90 for
+// a phone held upright) while the legacy rotate tag is clockwise.
+func streamRotation(rotateTag string, sideDataList []sideData) int {
+	rotation := 0
+	found := false
+	for _, data := range sideDataList {
+		if data.SideDataType == "Display Matrix" {
+			rotation = -int(math.Round(data.Rotation))
+			found = true
+			break
+		}
+	}
+	if !found && rotateTag != "" {
+		rotation, _ = strconv.Atoi(rotateTag)
+	}
+
+	rotation %= 360
+	if rotation < 0 {
+		rotation += 360
+	}
+
+	return rotation
+}
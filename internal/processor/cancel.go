@@ -0,0 +1,64 @@
+package processor
+
+import (
+	"context"
+	"time"
+
+	"upload/internal/meta"
+	"upload/internal/store"
+)
+
+// cancelPollInterval is how often a running job re-reads its metadata to see
+// whether an operator canceled it (e.g. `videoctl cancel` from another process).
+const cancelPollInterval = 2 * time.Second
+
+// cancelAwareStore keeps a canceled status sticky: any Update made by the
+// pipeline after the record was canceled keeps the canceled status and stops
+// the job instead of overwriting it with stale state.
+type cancelAwareStore struct {
+	meta.Store
+	cancel context.CancelFunc
+}
+
+func (s *cancelAwareStore) Update(m meta.Metadata) error {
+	if current, err := s.Store.Get(m.ID); err == nil && current.Status == string(store.StatusCanceled) {
+		s.cancel()
+		m.Status = current.Status
+		m.ErrorMessage = current.ErrorMessage
+	}
+	return s.Store.Update(m)
+}
+
+func (s *cancelAwareStore) Modify(id string, fn func(m *meta.Metadata) error) (meta.Metadata, error) {
+	return s.Store.Modify(id, func(m *meta.Metadata) error {
+		status, message := m.Status, m.ErrorMessage
+		if err := fn(m); err != nil {
+			return err
+		}
+		if status == string(store.StatusCanceled) {
+			s.cancel()
+			m.Status = status
+			m.ErrorMessage = message
+		}
+		return nil
+	})
+}
+
+// watchCancel cancels ctx once the video's status becomes canceled, which
+// kills any running ffmpeg through exec.CommandContext.
+func watchCancel(ctx context.Context, metaStore meta.Store, videoID string, cancel context.CancelFunc) {
+	ticker := time.NewTicker(cancelPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if m, err := metaStore.Get(videoID); err == nil && m.Status == string(store.StatusCanceled) {
+				cancel()
+				return
+			}
+		}
+	}
+}
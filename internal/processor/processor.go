@@ -3,13 +3,15 @@ package processor
 import (
 	"context"
 	"log"
-	"path/filepath"
 	"time"
 
 	"upload/internal/config"
 	"upload/internal/exec"
+	"upload/internal/fsutil"
+	"upload/internal/hls"
 	"upload/internal/meta"
 	"upload/internal/probe"
+	"upload/internal/quota"
 	"upload/internal/thumbnail"
 	"upload/internal/transcoder"
 )
@@ -18,6 +20,7 @@ type Processor struct {
 	cfg    config.Config
 	store  meta.Store
 	runner exec.Runner
+	ledger *quota.Ledger
 }
 
 func New(cfg config.Config, store meta.Store) *Processor {
@@ -25,65 +28,173 @@ func New(cfg config.Config, store meta.Store) *Processor {
 		cfg:    cfg,
 		store:  store,
 		runner: exec.NewCommandRunner(),
+		ledger: quota.NewLedger(cfg.StorageDir),
 	}
 }
 
 func (p *Processor) ProcessVideo(videoID string) {
-	ctx := context.Background()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := &cancelAwareStore{Store: meta.KeepDetails(p.store), cancel: cancel}
+	go watchCancel(ctx, p.store, videoID, cancel)
+
 	prober := probe.NewProber(p.cfg, p.runner)
 	thumbGen := thumbnail.NewGenerator(p.cfg, p.runner)
-	transcdr := transcoder.NewTranscoder(p.cfg, p.runner, p.store)
+	transcdr := transcoder.NewTranscoder(p.cfg, p.runner, store)
 
 	log.Printf("Starting processing for video: %s", videoID)
 
 	// Get metadata
-	m, err := p.store.Get(videoID)
+	m, err := store.Get(videoID)
 	if err != nil {
 		log.Printf("Failed to get metadata for %s: %v", videoID, err)
 		return
 	}
+	if m.Status == "canceled" {
+		log.Printf("Skipping canceled video: %s", videoID)
+		return
+	}
 
-	inputPath := filepath.Join(p.cfg.StorageDir, "originals", videoID, "original"+filepath.Ext(m.OriginalFilename))
+	root := fsutil.TenantRoot(p.cfg.StorageDir, m.Tenant)
+	inputPath := fsutil.OriginalPath(root, videoID, m.OriginalFilename)
 
 	// Extract video info with FFprobe
 	videoInfo, err := prober.ProbeVideo(ctx, inputPath)
 	if err != nil {
 		log.Printf("Failed to probe video %s (FFprobe not available): %v", videoID, err)
 		// Skip video analysis if FFprobe is not available, but continue with basic metadata
-		m.Status = "processing"
-		m.ErrorMessage = "FFprobe not available, skipping video analysis"
-		p.store.Update(m)
+		store.Modify(videoID, func(m *meta.Metadata) error {
+			m.Status = "processing"
+			m.ErrorMessage = "FFprobe not available, skipping video analysis"
+			return nil
+		})
 
 		// Try to generate thumbnails anyway (will also fail gracefully)
 		thumbGen := thumbnail.NewGenerator(p.cfg, p.runner)
 		thumbOpts := thumbnail.DefaultOptions()
+		thumbOpts.Root = root
 		_, thumbErr := thumbGen.GenerateThumbnails(ctx, videoID, inputPath, 0, thumbOpts)
 		if thumbErr != nil {
 			log.Printf("Failed to generate thumbnails for %s: %v", videoID, thumbErr)
 		}
 
 		// Mark as failed since we can't process without FFmpeg
-		m.Status = "failed"
-		m.ErrorMessage = "FFmpeg/FFprobe not installed. Please install FFmpeg to enable video processing."
-		p.store.Update(m)
+		store.Modify(videoID, func(m *meta.Metadata) error {
+			m.Status = "failed"
+			m.ErrorMessage = "FFmpeg/FFprobe not installed. Please install FFmpeg to enable video processing."
+			return nil
+		})
 		return
 	}
 
 	// Update metadata with video info
-	m.DurationSec = videoInfo.Duration
-	m.Width = videoInfo.Width
-	m.Height = videoInfo.Height
-	m.FPS = videoInfo.FPS
-	m.UpdatedAt = time.Now()
-	p.store.Update(m)
+	store.Modify(videoID, func(m *meta.Metadata) error {
+		m.DurationSec = videoInfo.Duration
+		m.Width = videoInfo.DisplayWidth
+		m.Height = videoInfo.DisplayHeight
+		m.Rotation = videoInfo.Rotation
+		m.FPS = videoInfo.FPS
+		m.AudioCodec = videoInfo.AudioCodec
+		m.AudioTracks = m.AudioTracks[:0]
+		for _, stream := range videoInfo.AudioStreams {
+			m.AudioTracks = append(m.AudioTracks, meta.AudioTrack{
+				Index:    stream.Index,
+				Codec:    stream.Codec,
+				Channels: stream.Channels,
+				Language: stream.Language,
+				Title:    stream.Title,
+				Default:  stream.Default,
+			})
+		}
+		// uploaded subtitle files survive a reprocess; embedded ones are re-read
+		subtitles := m.SubtitleTracks[:0]
+		for _, track := range m.SubtitleTracks {
+			if track.Source != "embedded" {
+				subtitles = append(subtitles, track)
+			}
+		}
+		for _, stream := range videoInfo.SubtitleStreams {
+			if !stream.Text() {
+				log.Printf("Skipping %s subtitle stream %d of %s: bitmap subtitles are not supported", stream.Codec, stream.Index, videoID)
+				continue
+			}
+			subtitles = append(subtitles, meta.SubtitleTrack{
+				Source:   "embedded",
+				Index:    stream.Index,
+				Codec:    stream.Codec,
+				Language: hls.Language(stream.Language),
+				Name:     stream.Title,
+				Default:  stream.Default,
+				Forced:   stream.Forced,
+			})
+		}
+		m.SubtitleTracks = subtitles
+		return nil
+	})
 
 	// Generate thumbnails
 	thumbOpts := thumbnail.DefaultOptions()
+	thumbOpts.Root = root
 	thumbnails, err := thumbGen.GenerateThumbnails(ctx, videoID, inputPath, videoInfo.Duration, thumbOpts)
 	if err != nil {
 		log.Printf("Failed to generate thumbnails for %s: %v", videoID, err)
 	} else {
-		log.Printf("Generated %d thumbnails for %s", len(thumbnails), videoID)
+		log.Printf("Generated %d thumbnails for %s", len(thumbnails.Images), videoID)
+	}
+	// 실패해도 만들어진 것까지는 기록
+	store.Modify(videoID, func(m *meta.Metadata) error {
+		m.Thumbnails = nil
+		if len(thumbnails.Images) > 0 {
+			m.Thumbnails = &thumbnails
+		}
+		return nil
+	})
+
+	// 스크럽바 미리보기용 스프라이트 시트 (SPRITE_INTERVAL=0 이면 생략)
+	if p.cfg.SpriteInterval > 0 {
+		spriteOpts := thumbnail.DefaultSpriteOptions()
+		spriteOpts.Interval = p.cfg.SpriteInterval.Seconds()
+		spriteOpts.Width = p.cfg.SpriteWidth
+		spriteOpts.Columns = p.cfg.SpriteColumns
+		spriteOpts.Rows = p.cfg.SpriteRows
+		spriteOpts.Root = root
+		var sheets *meta.SpriteSheets
+		sprites, err := thumbGen.GenerateSprites(ctx, videoID, inputPath, videoInfo.Duration, spriteOpts)
+		if err != nil {
+			log.Printf("Failed to generate sprite sheets for %s: %v", videoID, err)
+		} else {
+			log.Printf("Generated %d sprite sheets for %s", len(sprites.Images), videoID)
+			sheets = &sprites
+		}
+		store.Modify(videoID, func(m *meta.Metadata) error {
+			m.Sprites = sheets
+			return nil
+		})
+	}
+
+	// 그리드 hover 용 애니메이션 미리보기 (PREVIEW_ENABLED)
+	if p.cfg.PreviewEnabled {
+		previewOpts := thumbnail.DefaultPreviewOptions()
+		previewOpts.Snippets = p.cfg.PreviewSnippets
+		previewOpts.SnippetDuration = p.cfg.PreviewSnippetDuration.Seconds()
+		previewOpts.MaxDuration = p.cfg.PreviewMaxDuration.Seconds()
+		previewOpts.SceneDetect = p.cfg.PreviewMode == "scene"
+		previewOpts.Width = p.cfg.PreviewWidth
+		previewOpts.MaxBytes = int64(p.cfg.PreviewMaxKB) * 1024
+		previewOpts.DisplayWidth = videoInfo.DisplayWidth
+		previewOpts.DisplayHeight = videoInfo.DisplayHeight
+		previewOpts.Root = root
+		var animated *meta.AnimatedPreview
+		preview, err := thumbGen.GeneratePreview(ctx, videoID, inputPath, videoInfo.Duration, previewOpts)
+		if err != nil {
+			log.Printf("Failed to generate preview for %s: %v", videoID, err)
+		} else {
+			animated = &preview
+		}
+		store.Modify(videoID, func(m *meta.Metadata) error {
+			m.Preview = animated
+			return nil
+		})
 	}
 
 	// Start transcoding
@@ -91,6 +202,10 @@ func (p *Processor) ProcessVideo(videoID string) {
 		log.Printf("Failed to transcode video %s: %v", videoID, err)
 		return
 	}
+	// 인코딩 시간은 원본 길이 기준으로 테넌트 월 사용량에 기록
+	if err := p.ledger.Add(m.Tenant, time.Now(), videoInfo.Duration/60); err != nil {
+		log.Printf("Failed to record encoding usage for %s: %v", videoID, err)
+	}
 
 	log.Printf("Successfully processed video: %s", videoID)
 }
@@ -0,0 +1,76 @@
+package processor
+
+import (
+	"log"
+	"sync"
+)
+
+// Queue runs ProcessVideo on a fixed number of workers so a burst of uploads
+// doesn't start an ffmpeg per video.
+type Queue struct {
+	proc *Processor
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	pending []string
+	closed  bool
+	wg      sync.WaitGroup
+}
+
+func NewQueue(proc *Processor, workers int) *Queue {
+	if workers <= 0 {
+		workers = 1
+	}
+
+	queue := &Queue{proc: proc}
+	queue.cond = sync.NewCond(&queue.mu)
+	for i := 0; i < workers; i++ {
+		queue.wg.Add(1)
+		go queue.work()
+	}
+
+	return queue
+}
+
+// Enqueue schedules a video for processing. It never blocks.
+func (queue *Queue) Enqueue(videoID string) {
+	queue.mu.Lock()
+	defer queue.mu.Unlock()
+
+	if queue.closed {
+		log.Printf("Queue closed, dropping video: %s", videoID)
+		return
+	}
+	queue.pending = append(queue.pending, videoID)
+	queue.cond.Signal()
+}
+
+// Close stops accepting work and waits for pending jobs to finish.
+func (queue *Queue) Close() {
+	queue.mu.Lock()
+	queue.closed = true
+	queue.cond.Broadcast()
+	queue.mu.Unlock()
+
+	queue.wg.Wait()
+}
+
+func (queue *Queue) work() {
+	defer queue.wg.Done()
+
+	for {
+		queue.mu.Lock()
+		for len(queue.pending) == 0 && !queue.closed {
+			queue.cond.Wait()
+		}
+		if len(queue.pending) == 0 {
+			queue.mu.Unlock()
+			return
+		}
+		videoID := queue.pending[0]
+		queue.pending = queue.pending[1:]
+		queue.mu.Unlock()
+
+		queue.proc.ProcessVideo(videoID)
+	}
+}
@@ -0,0 +1,92 @@
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// defaultTenantDir names the default tenant's ledger directory; tenant names
+// can't start with '_'.
+const defaultTenantDir = "_default"
+
+// Ledger records encoded minutes per tenant and month under
+// <root>/usage/<tenant>/<YYYY-MM>.json.
+type Ledger struct {
+	root string
+	mu   sync.Mutex
+}
+
+type ledgerEntry struct {
+	Minutes float64 `json:"minutes"`
+	Videos  int     `json:"videos"`
+}
+
+func NewLedger(root string) *Ledger {
+	return &Ledger{root: root}
+}
+
+// Add records one encoded video of the given length in the month of at.
+func (ledger *Ledger) Add(tenant string, at time.Time, minutes float64) error {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	p := ledger.path(tenant, at)
+	entry, err := readEntry(p)
+	if err != nil {
+		return err
+	}
+	entry.Minutes += minutes
+	entry.Videos++
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(p+".tmp", data, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(p+".tmp", p)
+}
+
+// Minutes is what tenant encoded in the month of at.
+func (ledger *Ledger) Minutes(tenant string, at time.Time) (float64, error) {
+	ledger.mu.Lock()
+	defer ledger.mu.Unlock()
+
+	entry, err := readEntry(ledger.path(tenant, at))
+	return entry.Minutes, err
+}
+
+func (ledger *Ledger) path(tenant string, at time.Time) string {
+	if tenant == "" {
+		tenant = defaultTenantDir
+	}
+	return filepath.Join(ledger.root, "usage", tenant, month(at)+".json")
+}
+
+func readEntry(p string) (ledgerEntry, error) {
+	var entry ledgerEntry
+	data, err := os.ReadFile(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return entry, nil
+	}
+	if err != nil {
+		return entry, err
+	}
+
+	return entry, json.Unmarshal(data, &entry)
+}
+
+// month is the UTC calendar month quotas reset on.
+func month(at time.Time) string {
+	return at.UTC().Format("2006-01")
+}
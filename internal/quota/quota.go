@@ -0,0 +1,271 @@
+// Package quota enforces per-tenant storage and monthly encoding limits and
+// reports what each tenant uses.
+package quota
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+
+	"upload/internal/config"
+	"upload/internal/fsutil"
+	"upload/internal/meta"
+)
+
+var (
+	ErrStorageExceeded  = errors.New("storage quota exceeded")
+	ErrEncodingExceeded = errors.New("monthly encoding quota exceeded")
+)
+
+// Limits is one entry of the TENANTS_FILE JSON array. 0 means unlimited.
+type Limits struct {
+	Tenant                  string  `json:"name"`
+	StorageMB               int64   `json:"storage_quota_mb"`
+	EncodingMinutesPerMonth float64 `json:"encoding_minutes_per_month"`
+}
+
+// Usage is what a tenant uses against its limits; quotas are omitted when
+// unlimited.
+type Usage struct {
+	Tenant               string  `json:"tenant"`
+	Videos               int     `json:"videos"`
+	StorageBytes         int64   `json:"storage_bytes"`
+	StorageQuotaBytes    int64   `json:"storage_quota_bytes,omitempty"`
+	Month                string  `json:"month"`
+	EncodingMinutes      float64 `json:"encoding_minutes"`
+	EncodingQuotaMinutes float64 `json:"encoding_quota_minutes,omitempty"`
+}
+
+type Manager struct {
+	root   string
+	store  meta.Store
+	limits map[string]Limits
+	ledger *Ledger
+
+	mu       sync.Mutex
+	reserved map[string]int64 // bytes held by uploads in progress, per tenant
+}
+
+// NewManager loads TENANTS_FILE, if set. Without it every tenant is
+// unlimited but usage is still reported.
+func NewManager(cfg config.Config, store meta.Store) (*Manager, error) {
+	manager := &Manager{
+		root:     cfg.StorageDir,
+		store:    store,
+		limits:   map[string]Limits{},
+		ledger:   NewLedger(cfg.StorageDir),
+		reserved: map[string]int64{},
+	}
+	if cfg.TenantsFile == "" {
+		return manager, nil
+	}
+
+	data, err := os.ReadFile(cfg.TenantsFile)
+	if err != nil {
+		return nil, fmt.Errorf("read tenants: %w", err)
+	}
+	var tenants []Limits
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("parse tenants: %w", err)
+	}
+	for _, limits := range tenants {
+		if !fsutil.ValidTenant(limits.Tenant) {
+			return nil, fmt.Errorf("invalid tenant name %q", limits.Tenant)
+		}
+		if limits.StorageMB < 0 || limits.EncodingMinutesPerMonth < 0 {
+			return nil, fmt.Errorf("tenant %s: quotas must not be negative", limits.Tenant)
+		}
+		manager.limits[limits.Tenant] = limits
+	}
+
+	return manager, nil
+}
+
+// StorageRemaining is how many more bytes tenant may store, or -1 when its
+// storage is unlimited. Bytes reserved by uploads in progress count as used.
+func (manager *Manager) StorageRemaining(tenant string) (int64, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	return manager.remaining(tenant)
+}
+
+// Reservation holds part of a tenant's storage quota for one upload.
+type Reservation struct {
+	manager *Manager
+	tenant  string
+	bytes   int64 // -1 when the tenant's storage is unlimited
+	once    sync.Once
+}
+
+// Reserve sets aside size bytes of tenant's storage, capped at what is left
+// (everything left when size is negative, i.e. unknown), so concurrent
+// uploads can't both pass the quota check. The reservation is held until
+// Release.
+func (manager *Manager) Reserve(tenant string, size int64) (*Reservation, error) {
+	manager.mu.Lock()
+	defer manager.mu.Unlock()
+
+	remaining, err := manager.remaining(tenant)
+	if err != nil {
+		return nil, err
+	}
+	reservation := &Reservation{manager: manager, tenant: tenant, bytes: remaining}
+	if remaining < 0 {
+		return reservation, nil
+	}
+	if remaining == 0 {
+		return nil, ErrStorageExceeded
+	}
+	if size >= 0 {
+		reservation.bytes = min(size, remaining)
+	}
+	manager.reserved[tenant] += reservation.bytes
+
+	return reservation, nil
+}
+
+// Limit is the number of bytes reserved, or -1 when storage is unlimited.
+func (reservation *Reservation) Limit() int64 {
+	return reservation.bytes
+}
+
+// Release returns the reservation once the upload is on disk (and counted
+// by StorageUsed) or has failed. Calling it again does nothing.
+func (reservation *Reservation) Release() {
+	reservation.once.Do(func() {
+		if reservation.bytes < 0 {
+			return
+		}
+		manager := reservation.manager
+		manager.mu.Lock()
+		defer manager.mu.Unlock()
+		manager.reserved[reservation.tenant] -= reservation.bytes
+		if manager.reserved[reservation.tenant] <= 0 {
+			delete(manager.reserved, reservation.tenant)
+		}
+	})
+}
+
+// remaining is StorageRemaining with manager.mu held.
+func (manager *Manager) remaining(tenant string) (int64, error) {
+	quota := manager.limits[tenant].StorageMB * 1024 * 1024
+	if quota == 0 {
+		return -1, nil
+	}
+	used, err := StorageUsed(manager.root, tenant)
+	if err != nil {
+		return 0, err
+	}
+
+	return max(quota-used-manager.reserved[tenant], 0), nil
+}
+
+// CheckEncoding returns ErrEncodingExceeded once tenant has used up this
+// month's encoding minutes. The video that crosses the limit still finishes:
+// its duration is only known after probing.
+func (manager *Manager) CheckEncoding(tenant string) error {
+	quota := manager.limits[tenant].EncodingMinutesPerMonth
+	if quota == 0 {
+		return nil
+	}
+	used, err := manager.ledger.Minutes(tenant, time.Now())
+	if err != nil {
+		return err
+	}
+	if used >= quota {
+		return ErrEncodingExceeded
+	}
+
+	return nil
+}
+
+// Usage reports tenant's videos, storage and this month's encoding minutes.
+func (manager *Manager) Usage(tenant string) (Usage, error) {
+	now := time.Now()
+	limits := manager.limits[tenant]
+	usage := Usage{
+		Tenant:               tenant,
+		StorageQuotaBytes:    limits.StorageMB * 1024 * 1024,
+		Month:                month(now),
+		EncodingQuotaMinutes: limits.EncodingMinutesPerMonth,
+	}
+
+	videos, err := manager.store.List()
+	if err != nil {
+		return Usage{}, err
+	}
+	for _, m := range videos {
+		if m.Tenant == tenant {
+			usage.Videos++
+		}
+	}
+	if usage.StorageBytes, err = StorageUsed(manager.root, tenant); err != nil {
+		return Usage{}, err
+	}
+	if usage.EncodingMinutes, err = manager.ledger.Minutes(tenant, now); err != nil {
+		return Usage{}, err
+	}
+
+	return usage, nil
+}
+
+// Tenants lists the configured tenants plus every tenant that has videos,
+// the default tenant ("") first.
+func (manager *Manager) Tenants() ([]string, error) {
+	seen := map[string]bool{"": true}
+	for tenant := range manager.limits {
+		seen[tenant] = true
+	}
+	videos, err := manager.store.List()
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range videos {
+		seen[m.Tenant] = true
+	}
+
+	tenants := make([]string, 0, len(seen))
+	for tenant := range seen {
+		tenants = append(tenants, tenant)
+	}
+	slices.Sort(tenants)
+
+	return tenants, nil
+}
+
+// StorageUsed sums the files of tenant's originals, outputs, thumbnails and
+// keys on disk.
+func StorageUsed(root, tenant string) (int64, error) {
+	var total int64
+	tenantRoot := fsutil.TenantRoot(root, tenant)
+	for _, area := range fsutil.Areas {
+		err := filepath.WalkDir(filepath.Join(tenantRoot, area), func(p string, d fs.DirEntry, err error) error {
+			if err != nil {
+				if errors.Is(err, fs.ErrNotExist) {
+					return nil
+				}
+				return err
+			}
+			if d.Type().IsRegular() {
+				info, err := d.Info()
+				if err != nil {
+					return nil
+				}
+				total += info.Size()
+			}
+			return nil
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return total, nil
+}
@@ -0,0 +1,125 @@
+package quota
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"upload/internal/fsutil"
+)
+
+const mb = 1024 * 1024
+
+// exceeded marks an expected ErrStorageExceeded in TestReserve.
+const exceeded = -2
+
+func newTestManager(t *testing.T, used int64) *Manager {
+	t.Helper()
+	root := t.TempDir()
+	original := fsutil.OriginalPath(fsutil.TenantRoot(root, "acme"), "v1", "original.mp4")
+	if err := os.MkdirAll(filepath.Dir(original), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(original, make([]byte, used), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	return &Manager{
+		root:     root,
+		limits:   map[string]Limits{"acme": {Tenant: "acme", StorageMB: 1}},
+		reserved: map[string]int64{},
+	}
+}
+
+func TestStorageRemaining(t *testing.T) {
+	manager := newTestManager(t, mb/4)
+
+	remaining, err := manager.StorageRemaining("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != mb-mb/4 {
+		t.Fatalf("StorageRemaining() = %d, want %d", remaining, mb-mb/4)
+	}
+	if remaining, _ := manager.StorageRemaining("globex"); remaining != -1 {
+		t.Fatalf("StorageRemaining() of unlimited tenant = %d, want -1", remaining)
+	}
+
+	full := newTestManager(t, 2*mb)
+	if remaining, _ := full.StorageRemaining("acme"); remaining != 0 {
+		t.Fatalf("StorageRemaining() over quota = %d, want 0", remaining)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	tests := []struct {
+		name  string
+		used  int64
+		sizes []int64 // reserved in order, none released
+		limit []int64 // Limit() of each, or exceeded
+	}{
+		{name: "fits", used: 0, sizes: []int64{mb / 2, mb / 2}, limit: []int64{mb / 2, mb / 2}},
+		{name: "second capped", used: 0, sizes: []int64{mb / 2, mb}, limit: []int64{mb / 2, mb / 2}},
+		{name: "nothing left", used: 0, sizes: []int64{mb, 1}, limit: []int64{mb, exceeded}},
+		{name: "unknown size takes the rest", used: mb / 4, sizes: []int64{-1, 1}, limit: []int64{mb - mb/4, exceeded}},
+		{name: "over quota", used: 2 * mb, sizes: []int64{1}, limit: []int64{exceeded}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			manager := newTestManager(t, test.used)
+			for i, size := range test.sizes {
+				reservation, err := manager.Reserve("acme", size)
+				if test.limit[i] == exceeded {
+					if !errors.Is(err, ErrStorageExceeded) {
+						t.Fatalf("Reserve(%d) error = %v, want %v", size, err, ErrStorageExceeded)
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatalf("Reserve(%d) error = %v", size, err)
+				}
+				if reservation.Limit() != test.limit[i] {
+					t.Fatalf("Reserve(%d).Limit() = %d, want %d", size, reservation.Limit(), test.limit[i])
+				}
+			}
+		})
+	}
+}
+
+func TestReservationRelease(t *testing.T) {
+	manager := newTestManager(t, 0)
+
+	first, err := manager.Reserve("acme", mb/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := manager.Reserve("acme", mb/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining, _ := manager.StorageRemaining("acme"); remaining != 0 {
+		t.Fatalf("StorageRemaining() while reserved = %d, want 0", remaining)
+	}
+
+	// releasing twice must not hand back the other upload's bytes
+	first.Release()
+	first.Release()
+	if remaining, _ := manager.StorageRemaining("acme"); remaining != mb/2 {
+		t.Fatalf("StorageRemaining() after release = %d, want %d", remaining, mb/2)
+	}
+	second.Release()
+	if remaining, _ := manager.StorageRemaining("acme"); remaining != mb {
+		t.Fatalf("StorageRemaining() after both releases = %d, want %d", remaining, mb)
+	}
+
+	unlimited, err := manager.Reserve("globex", mb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unlimited.Limit() != -1 {
+		t.Fatalf("Reserve() of unlimited tenant Limit() = %d, want -1", unlimited.Limit())
+	}
+	unlimited.Release()
+}
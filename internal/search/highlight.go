@@ -0,0 +1,72 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// excerptRunes is roughly how much text a highlight shows around the first
+// match of long fields.
+const excerptRunes = 160
+
+// highlight returns text, or an excerpt of it around the first match, with
+// every token in terms wrapped in <mark>. ok is false when nothing matches.
+func highlight(text string, terms map[string]bool) (string, bool) {
+	runes := []rune(text)
+	type span struct{ start, end int }
+	var matches []span
+	for i := 0; i < len(runes); {
+		if !isTokenRune(runes[i]) {
+			i++
+			continue
+		}
+		start := i
+		for i < len(runes) && isTokenRune(runes[i]) {
+			i++
+		}
+		if terms[strings.ToLower(string(runes[start:i]))] {
+			matches = append(matches, span{start, i})
+		}
+	}
+	if len(matches) == 0 {
+		return "", false
+	}
+
+	from, to := 0, len(runes)
+	if len(runes) > excerptRunes {
+		from = max(matches[0].start-excerptRunes/4, 0)
+		to = min(from+excerptRunes, len(runes))
+		// don't cut words in half
+		for from > 0 && isTokenRune(runes[from-1]) {
+			from--
+		}
+		for to < len(runes) && isTokenRune(runes[to]) {
+			to++
+		}
+	}
+
+	var b strings.Builder
+	if from > 0 {
+		b.WriteString("…")
+	}
+	position := from
+	for _, match := range matches {
+		if match.start < from || match.end > to {
+			continue
+		}
+		b.WriteString(html.EscapeString(string(runes[position:match.start])))
+		b.WriteString("<mark>" + html.EscapeString(string(runes[match.start:match.end])) + "</mark>")
+		position = match.end
+	}
+	b.WriteString(html.EscapeString(string(runes[position:to])))
+	if to < len(runes) {
+		b.WriteString("…")
+	}
+
+	return strings.Join(strings.Fields(b.String()), " "), true
+}
+
+func isTokenRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r)
+}
@@ -0,0 +1,308 @@
+// Package search keeps an in-memory full-text index over video metadata:
+// original filename, title, description, tags and subtitle text.
+package search
+
+import (
+	"math"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+
+	"upload/internal/meta"
+)
+
+// Fields in the order they are weighted and highlighted.
+const (
+	FieldTitle       = "title"
+	FieldTags        = "tags"
+	FieldFilename    = "filename"
+	FieldDescription = "description"
+	FieldSubtitles   = "subtitles"
+)
+
+var fields = []string{FieldTitle, FieldTags, FieldFilename, FieldDescription, FieldSubtitles}
+
+// weights favour matches in short, deliberate fields over long free text.
+var weights = map[string]float64{
+	FieldTitle:       3,
+	FieldTags:        2.5,
+	FieldFilename:    1.5,
+	FieldDescription: 1,
+	FieldSubtitles:   0.5,
+}
+
+// BM25 parameters
+const (
+	k1 = 1.2
+	b  = 0.75
+)
+
+type document struct {
+	text      map[string]string // field -> original text, for highlighting
+	length    map[string]int    // field -> number of terms
+	subtitles string            // signature of the subtitle renditions read
+}
+
+type Index struct {
+	root string // StorageDir, to read subtitle renditions
+
+	mu       sync.RWMutex
+	docs     map[string]*document
+	postings map[string]map[string]map[string]int // term -> doc -> field -> frequency
+	lengths  map[string]int                       // field -> total terms, for average lengths
+}
+
+func NewIndex(root string) *Index {
+	return &Index{
+		root:     root,
+		docs:     map[string]*document{},
+		postings: map[string]map[string]map[string]int{},
+		lengths:  map[string]int{},
+	}
+}
+
+// Put indexes m, replacing an earlier version of the same video.
+func (index *Index) Put(m meta.Metadata) {
+	index.mu.RLock()
+	previous := index.docs[m.ID]
+	index.mu.RUnlock()
+
+	// subtitle text is read from disk only when the renditions changed
+	signature := subtitleSignature(m)
+	subtitles := ""
+	if previous != nil && previous.subtitles == signature {
+		subtitles = previous.text[FieldSubtitles]
+	} else if signature != "" {
+		subtitles = subtitleText(index.root, m)
+	}
+
+	doc := &document{
+		text: map[string]string{
+			FieldTitle:       m.Title,
+			FieldTags:        strings.Join(m.Tags, " "),
+			FieldFilename:    m.OriginalFilename,
+			FieldDescription: m.Description,
+			FieldSubtitles:   subtitles,
+		},
+		length:    map[string]int{},
+		subtitles: signature,
+	}
+
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.remove(m.ID)
+	for _, field := range fields {
+		terms := Tokenize(doc.text[field])
+		doc.length[field] = len(terms)
+		index.lengths[field] += len(terms)
+		for _, term := range terms {
+			docs := index.postings[term]
+			if docs == nil {
+				docs = map[string]map[string]int{}
+				index.postings[term] = docs
+			}
+			if docs[m.ID] == nil {
+				docs[m.ID] = map[string]int{}
+			}
+			docs[m.ID][field]++
+		}
+	}
+	index.docs[m.ID] = doc
+}
+
+// Remove drops a video from the index.
+func (index *Index) Remove(id string) {
+	index.mu.Lock()
+	defer index.mu.Unlock()
+	index.remove(id)
+}
+
+func (index *Index) remove(id string) {
+	doc, ok := index.docs[id]
+	if !ok {
+		return
+	}
+	for _, field := range fields {
+		index.lengths[field] -= doc.length[field]
+		for _, term := range Tokenize(doc.text[field]) {
+			if docs := index.postings[term]; docs != nil {
+				delete(docs, id)
+				if len(docs) == 0 {
+					delete(index.postings, term)
+				}
+			}
+		}
+	}
+	delete(index.docs, id)
+}
+
+// Rebuild replaces the whole index with videos.
+func (index *Index) Rebuild(videos []meta.Metadata) {
+	index.mu.Lock()
+	index.docs = map[string]*document{}
+	index.postings = map[string]map[string]map[string]int{}
+	index.lengths = map[string]int{}
+	index.mu.Unlock()
+
+	for _, m := range videos {
+		index.Put(m)
+	}
+}
+
+// Hit is one ranked search result. Highlights holds, per matching field,
+// an HTML-escaped excerpt with the matched terms wrapped in <mark>.
+type Hit struct {
+	ID         string            `json:"id"`
+	Score      float64           `json:"score"`
+	Highlights map[string]string `json:"highlights,omitempty"`
+}
+
+// Search ranks the videos matching every term of query (a trailing '*'
+// matches a prefix) with BM25 over the weighted fields. Only videos allow
+// accepts are counted and returned; offset and limit page through them.
+// allow is called without the index locked, so it may hit the store.
+func (index *Index) Search(query string, allow func(id string) bool, offset, limit int) ([]Hit, int) {
+	ranked, matched := index.rank(parseQuery(query))
+	if allow != nil {
+		allowed := ranked[:0]
+		for _, hit := range ranked {
+			if allow(hit.ID) {
+				allowed = append(allowed, hit)
+			}
+		}
+		ranked = allowed
+	}
+
+	total := len(ranked)
+	if offset >= total {
+		return []Hit{}, total
+	}
+	ranked = ranked[offset:min(offset+limit, total)]
+
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+	for i := range ranked {
+		if _, ok := index.docs[ranked[i].ID]; ok {
+			ranked[i].Highlights = index.highlights(ranked[i].ID, matched[ranked[i].ID])
+		}
+	}
+
+	return ranked, total
+}
+
+// rank scores every video matching all terms, best first, and reports which
+// indexed terms matched in each.
+func (index *Index) rank(terms []string) ([]Hit, map[string]map[string]bool) {
+	index.mu.RLock()
+	defer index.mu.RUnlock()
+
+	if len(terms) == 0 {
+		return nil, nil
+	}
+
+	scores := map[string]float64{}
+	matched := map[string]map[string]bool{} // doc -> matched terms, for highlighting
+	for i, term := range terms {
+		hits := map[string]float64{}
+		for _, expanded := range index.expand(term) {
+			docs := index.postings[expanded]
+			idf := math.Log(1 + (float64(len(index.docs))-float64(len(docs))+0.5)/(float64(len(docs))+0.5))
+			for id, freqs := range docs {
+				hits[id] += idf * index.fieldScore(id, freqs)
+				if matched[id] == nil {
+					matched[id] = map[string]bool{}
+				}
+				matched[id][expanded] = true
+			}
+		}
+		// every term must match
+		if i == 0 {
+			scores = hits
+			continue
+		}
+		for id := range scores {
+			if _, ok := hits[id]; !ok {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += hits[id]
+		}
+	}
+
+	ranked := make([]Hit, 0, len(scores))
+	for id, score := range scores {
+		ranked = append(ranked, Hit{ID: id, Score: math.Round(score*1000) / 1000})
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		if ranked[i].Score != ranked[j].Score {
+			return ranked[i].Score > ranked[j].Score
+		}
+		return ranked[i].ID < ranked[j].ID
+	})
+
+	return ranked, matched
+}
+
+// expand resolves a prefix term ("trav*") to the indexed terms it matches.
+func (index *Index) expand(term string) []string {
+	prefix, ok := strings.CutSuffix(term, "*")
+	if !ok {
+		return []string{term}
+	}
+	var terms []string
+	for indexed := range index.postings {
+		if strings.HasPrefix(indexed, prefix) {
+			terms = append(terms, indexed)
+		}
+	}
+	return terms
+}
+
+func (index *Index) fieldScore(id string, freqs map[string]int) float64 {
+	doc := index.docs[id]
+	var score float64
+	for field, tf := range freqs {
+		average := float64(index.lengths[field]) / float64(max(len(index.docs), 1))
+		norm := 1 - b + b*float64(doc.length[field])/math.Max(average, 1)
+		score += weights[field] * float64(tf) * (k1 + 1) / (float64(tf) + k1*norm)
+	}
+	return score
+}
+
+func (index *Index) highlights(id string, terms map[string]bool) map[string]string {
+	doc := index.docs[id]
+	highlights := map[string]string{}
+	for _, field := range fields {
+		if excerpt, ok := highlight(doc.text[field], terms); ok {
+			highlights[field] = excerpt
+		}
+	}
+	return highlights
+}
+
+// Tokenize lowercases text and splits it into letter/digit runs.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// parseQuery tokenizes query, keeping a trailing '*' on prefix terms.
+func parseQuery(query string) []string {
+	var terms []string
+	for _, word := range strings.Fields(query) {
+		prefix := strings.HasSuffix(word, "*")
+		tokens := Tokenize(word)
+		if prefix && len(tokens) > 0 {
+			tokens[len(tokens)-1] += "*"
+		}
+		for _, token := range tokens {
+			if !slices.Contains(terms, token) {
+				terms = append(terms, token)
+			}
+		}
+	}
+	return terms
+}
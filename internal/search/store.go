@@ -0,0 +1,159 @@
+package search
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"upload/internal/fsutil"
+	"upload/internal/meta"
+	"upload/internal/subtitle"
+)
+
+// refreshInterval is how often Refresh looks for records written around
+// the store.
+const refreshInterval = 2 * time.Second
+
+// IndexedStore keeps index in step with every record written through it.
+// Records written by another process (`videoctl ingest`, `reprocess`,
+// `delete`, `cancel`) are picked up by Refresh.
+type IndexedStore struct {
+	meta.Store
+	index *Index
+
+	mu      sync.Mutex
+	checked time.Time
+	seen    map[string]time.Time // id -> modification time when last indexed
+}
+
+// modifiedLister is a store that can tell when each record last changed,
+// like meta.JSONStore.
+type modifiedLister interface {
+	Modified() (map[string]time.Time, error)
+}
+
+func NewIndexedStore(store meta.Store, index *Index) *IndexedStore {
+	return &IndexedStore{Store: store, index: index, seen: map[string]time.Time{}}
+}
+
+// Refresh re-indexes every record whose file changed since it was last
+// indexed and drops deleted ones. It checks at most once per
+// refreshInterval; stores that can't report modification times are left
+// alone.
+func (indexed *IndexedStore) Refresh() {
+	lister, ok := indexed.Store.(modifiedLister)
+	if !ok {
+		return
+	}
+	indexed.mu.Lock()
+	defer indexed.mu.Unlock()
+	if time.Since(indexed.checked) < refreshInterval {
+		return
+	}
+	indexed.checked = time.Now()
+
+	modified, err := lister.Modified()
+	if err != nil {
+		log.Printf("Search refresh: %v", err)
+		return
+	}
+	for id, at := range modified {
+		if seen, ok := indexed.seen[id]; ok && seen.Equal(at) {
+			continue
+		}
+		m, err := indexed.Store.Get(id)
+		if err != nil {
+			continue
+		}
+		indexed.index.Put(m)
+		indexed.seen[id] = at
+	}
+	for id := range indexed.seen {
+		if _, ok := modified[id]; !ok {
+			indexed.index.Remove(id)
+			delete(indexed.seen, id)
+		}
+	}
+}
+
+func (indexed *IndexedStore) Create(m meta.Metadata) error {
+	if err := indexed.Store.Create(m); err != nil {
+		return err
+	}
+	indexed.index.Put(m)
+	return nil
+}
+
+func (indexed *IndexedStore) Update(m meta.Metadata) error {
+	if err := indexed.Store.Update(m); err != nil {
+		return err
+	}
+	indexed.index.Put(m)
+	return nil
+}
+
+func (indexed *IndexedStore) Modify(id string, fn func(m *meta.Metadata) error) (meta.Metadata, error) {
+	m, err := indexed.Store.Modify(id, fn)
+	if err != nil {
+		return meta.Metadata{}, err
+	}
+	indexed.index.Put(m)
+	return m, nil
+}
+
+func (indexed *IndexedStore) Delete(id string) error {
+	if err := indexed.Store.Delete(id); err != nil {
+		return err
+	}
+	indexed.index.Remove(id)
+	return nil
+}
+
+// subtitleSignature identifies the subtitle renditions of m, so their text
+// is only re-read when they change.
+func subtitleSignature(m meta.Metadata) string {
+	var b strings.Builder
+	for _, v := range m.Variants {
+		if v.Kind == "subtitles" {
+			fmt.Fprintf(&b, "%s@%d;", v.PathOrPl, v.ReadyAtUnix)
+		}
+	}
+	return b.String()
+}
+
+// subtitleText collects the cue text of every packaged subtitle rendition.
+// Cues spanning a segment boundary are repeated in both segments; repeats
+// are dropped.
+func subtitleText(root string, m meta.Metadata) string {
+	outputDir := fsutil.OutputsDir(fsutil.TenantRoot(root, m.Tenant), m.ID)
+	seen := map[string]bool{}
+	var lines []string
+	for _, v := range m.Variants {
+		if v.Kind != "subtitles" {
+			continue
+		}
+		segments, _ := filepath.Glob(filepath.Join(outputDir, filepath.FromSlash(path.Dir(v.PathOrPl)), "*.vtt"))
+		for _, segment := range segments {
+			data, err := os.ReadFile(segment)
+			if err != nil {
+				continue
+			}
+			cues, err := subtitle.ParseVTT(data)
+			if err != nil {
+				continue
+			}
+			for _, cue := range cues {
+				if !seen[cue.Text] {
+					seen[cue.Text] = true
+					lines = append(lines, cue.Text)
+				}
+			}
+		}
+	}
+	return strings.Join(lines, "\n")
+}
@@ -0,0 +1,107 @@
+package signing
+
+import (
+	"errors"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignerVerify(t *testing.T) {
+	signer := NewSigner("s3cret")
+	future := time.Now().Add(time.Hour)
+	past := time.Now().Add(-time.Hour)
+
+	tests := []struct {
+		name     string
+		signed   string // path the signature was made for
+		grant    Grant
+		path     string // path requested
+		clientIP string
+		tamper   func(query url.Values)
+		err      error
+	}{
+		{name: "valid", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: future}, path: "/streams/v1/master.m3u8"},
+		{name: "other path", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: future}, path: "/streams/v2/master.m3u8", err: ErrInvalid},
+		{name: "expired", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: past}, path: "/streams/v1/master.m3u8", err: ErrExpired},
+		{
+			name: "extended expiry", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: past}, path: "/streams/v1/master.m3u8",
+			tamper: func(query url.Values) { query.Set("exp", "99999999999") },
+			err:    ErrInvalid,
+		},
+		{
+			name: "missing sig", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: future}, path: "/streams/v1/master.m3u8",
+			tamper: func(query url.Values) { query.Del("sig") },
+			err:    ErrMissing,
+		},
+		{
+			name: "bad exp", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: future}, path: "/streams/v1/master.m3u8",
+			tamper: func(query url.Values) { query.Set("exp", "soon") },
+			err:    ErrInvalid,
+		},
+		{name: "ip bound", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: future, IP: "10.0.0.1"}, path: "/streams/v1/master.m3u8", clientIP: "10.0.0.1"},
+		{name: "other ip", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: future, IP: "10.0.0.1"}, path: "/streams/v1/master.m3u8", clientIP: "10.0.0.2", err: ErrIPMismatch},
+		{
+			name: "ip dropped", signed: "/streams/v1/master.m3u8", grant: Grant{Expires: future, IP: "10.0.0.1"}, path: "/streams/v1/master.m3u8", clientIP: "10.0.0.2",
+			tamper: func(query url.Values) { query.Del("ip") },
+			err:    ErrInvalid,
+		},
+		{name: "prefix", signed: "/streams/v1/720p/index.m3u8", grant: Grant{Expires: future, Prefix: "/streams/v1/"}, path: "/streams/v1/dash/seg_3.m4s"},
+		{name: "outside prefix", signed: "/streams/v1/720p/index.m3u8", grant: Grant{Expires: future, Prefix: "/streams/v1/"}, path: "/streams/v2/dash/seg_3.m4s", err: ErrInvalid},
+		{name: "dot segments", signed: "/streams/v1/720p/index.m3u8", grant: Grant{Expires: future, Prefix: "/streams/v1/"}, path: "/streams/v1/../v2/master.m3u8", err: ErrInvalid},
+		{name: "prefix without slash", signed: "/streams/v1/index.m3u8", grant: Grant{Expires: future, Prefix: "/streams/v1"}, path: "/streams/v10/index.m3u8", err: ErrInvalid},
+		{
+			name: "prefix widened", signed: "/streams/v1/720p/index.m3u8", grant: Grant{Expires: future, Prefix: "/streams/v1/"}, path: "/streams/v2/master.m3u8",
+			tamper: func(query url.Values) { query.Set("prefix", "/streams/") },
+			err:    ErrInvalid,
+		},
+		{
+			name: "single path used as prefix", signed: "/streams/v1/", grant: Grant{Expires: future}, path: "/streams/v1/master.m3u8",
+			tamper: func(query url.Values) { query.Set("prefix", "/streams/v1/") },
+			err:    ErrInvalid,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			query, err := url.ParseQuery(signer.Query(test.signed, test.grant))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if test.tamper != nil {
+				test.tamper(query)
+			}
+			_, err = signer.Verify(test.path, query, test.clientIP)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("Verify() error = %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestSignerVerifyOtherSecret(t *testing.T) {
+	grant := Grant{Expires: time.Now().Add(time.Hour)}
+	query, err := url.ParseQuery(NewSigner("s3cret").Query("/streams/v1/master.m3u8", grant))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewSigner("other").Verify("/streams/v1/master.m3u8", query, ""); !errors.Is(err, ErrInvalid) {
+		t.Fatalf("Verify() error = %v, want %v", err, ErrInvalid)
+	}
+}
+
+func TestSignerVerifyReturnsGrant(t *testing.T) {
+	signer := NewSigner("s3cret")
+	want := Grant{Expires: time.Unix(time.Now().Add(time.Hour).Unix(), 0), IP: "10.0.0.1", Prefix: "/streams/v1/"}
+	query, err := url.ParseQuery(signer.Query("/streams/v1/master.m3u8", want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := signer.Verify("/streams/v1/master.m3u8", query, "10.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.Expires.Equal(want.Expires) || got.IP != want.IP || got.Prefix != want.Prefix {
+		t.Fatalf("Verify() grant = %+v, want %+v", got, want)
+	}
+}
@@ -6,10 +6,12 @@ type Status string
 
 // go에선 공식적인 enum이 없고 아래와 같이 유사하게 지원한다고 한다.
 const (
+	StatusImporting  Status = "importing"
 	StatusQueued     Status = "queued"
 	StatusProcessing Status = "processing"
 	StatusReady      Status = "ready"
 	StatusFailed     Status = "failed"
+	StatusCanceled   Status = "canceled"
 )
 
 type VariantMeta struct {
@@ -0,0 +1,82 @@
+package subtitle
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SegmentOptions controls how a track is cut into an HLS WebVTT rendition.
+type SegmentOptions struct {
+	SegmentDuration time.Duration // 4s when zero
+	// Duration of the video. Segments cover all of it so the subtitle
+	// timeline lines up with the video playlists; the last cue's end is used
+	// when zero.
+	Duration time.Duration
+	// MPEGTS is the 90kHz media timestamp that cue time 0 maps to
+	// (X-TIMESTAMP-MAP), i.e. the first PTS of the video segments.
+	MPEGTS int64
+}
+
+// Segment writes cues into dir as seg_NNNNN.vtt files plus an index.m3u8 VOD
+// playlist. Cues spanning a boundary are repeated in every segment they
+// overlap, as HLS requires. It returns the total size of the written files.
+func Segment(cues []Cue, dir string, options SegmentOptions) (int64, error) {
+	segmentDuration := options.SegmentDuration
+	if segmentDuration <= 0 {
+		segmentDuration = 4 * time.Second
+	}
+	total := options.Duration
+	for _, cue := range cues {
+		if options.Duration == 0 && cue.End > total {
+			total = cue.End
+		}
+	}
+	if total <= 0 {
+		return 0, ErrNoCues
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, err
+	}
+
+	timestampMap := fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000", options.MPEGTS)
+	count := int(math.Ceil(float64(total) / float64(segmentDuration)))
+
+	var playlist strings.Builder
+	fmt.Fprintf(&playlist, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n",
+		int(math.Ceil(segmentDuration.Seconds())))
+
+	var size int64
+	for i := 0; i < count; i++ {
+		start := time.Duration(i) * segmentDuration
+		end := min(start+segmentDuration, total)
+
+		var b strings.Builder
+		b.WriteString(header(timestampMap))
+		var inSegment []Cue
+		for _, cue := range cues {
+			if cue.Start < end && cue.End > start {
+				inSegment = append(inSegment, cue)
+			}
+		}
+		writeCues(&b, inSegment)
+
+		name := fmt.Sprintf("seg_%05d.vtt", i)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(b.String()), 0644); err != nil {
+			return 0, err
+		}
+		size += int64(b.Len())
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", (end - start).Seconds(), name)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+
+	if err := os.WriteFile(filepath.Join(dir, "index.m3u8"), []byte(playlist.String()), 0644); err != nil {
+		return 0, err
+	}
+
+	return size + int64(playlist.Len()), nil
+}
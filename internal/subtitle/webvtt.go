@@ -0,0 +1,211 @@
+package subtitle
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrNoCues = errors.New("no subtitle cues found")
+
+// Cue is one timed block of caption text.
+type Cue struct {
+	ID       string
+	Start    time.Duration
+	End      time.Duration
+	Settings string // WebVTT cue settings, e.g. "line:0 align:start"
+	Text     string
+}
+
+// Parse reads SRT or WebVTT, chosen by format ("srt" or "vtt").
+func Parse(data []byte, format string) ([]Cue, error) {
+	switch format {
+	case "srt":
+		return ParseSRT(data)
+	case "vtt":
+		return ParseVTT(data)
+	}
+	return nil, fmt.Errorf("unsupported subtitle format %q", format)
+}
+
+// ParseVTT reads the cues of a WebVTT file. NOTE, STYLE and REGION blocks
+// are dropped.
+func ParseVTT(data []byte) ([]Cue, error) {
+	blocks := splitBlocks(data)
+	if len(blocks) == 0 || !strings.HasPrefix(blocks[0][0], "WEBVTT") {
+		return nil, errors.New("missing WEBVTT header")
+	}
+	return parseBlocks(blocks[1:], '.')
+}
+
+// ParseSRT reads a SubRip file. Cue numbers become cue IDs.
+func ParseSRT(data []byte) ([]Cue, error) {
+	return parseBlocks(splitBlocks(data), ',')
+}
+
+func parseBlocks(blocks [][]string, fraction byte) ([]Cue, error) {
+	var cues []Cue
+	for _, lines := range blocks {
+		timing := 0
+		if !strings.Contains(lines[0], "-->") {
+			if len(lines) < 2 || !strings.Contains(lines[1], "-->") {
+				// NOTE, STYLE, REGION or garbage
+				continue
+			}
+			timing = 1
+		}
+
+		startText, rest, _ := strings.Cut(lines[timing], "-->")
+		rest = strings.TrimSpace(rest)
+		endText, settings, _ := strings.Cut(rest, " ")
+		start, err := parseTimestamp(strings.TrimSpace(startText), fraction)
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseTimestamp(endText, fraction)
+		if err != nil {
+			return nil, err
+		}
+		if end <= start {
+			continue
+		}
+
+		cue := Cue{
+			Start:    start,
+			End:      end,
+			Settings: strings.TrimSpace(settings),
+			Text:     strings.Join(lines[timing+1:], "\n"),
+		}
+		if timing == 1 {
+			cue.ID = lines[0]
+		}
+		cues = append(cues, cue)
+	}
+	if len(cues) == 0 {
+		return nil, ErrNoCues
+	}
+
+	return cues, nil
+}
+
+// splitBlocks splits on blank lines, normalizing line endings and dropping a
+// UTF-8 BOM.
+func splitBlocks(data []byte) [][]string {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	data = bytes.ReplaceAll(data, []byte("\r"), []byte("\n"))
+
+	var blocks [][]string
+	var current []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = nil
+			}
+			continue
+		}
+		current = append(current, line)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+
+	return blocks
+}
+
+// parseTimestamp accepts hh:mm:ss.ttt and mm:ss.ttt; SRT uses a comma.
+func parseTimestamp(s string, fraction byte) (time.Duration, error) {
+	main, millis, ok := strings.Cut(s, string(fraction))
+	if !ok || len(millis) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	parts := strings.Split(main, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	var total time.Duration
+	for _, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		total = total*60 + time.Duration(n)*time.Second
+	}
+	ms, err := strconv.Atoi(millis)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+
+	return total + time.Duration(ms)*time.Millisecond, nil
+}
+
+func formatTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
+
+// header is the WEBVTT line plus an optional X-TIMESTAMP-MAP.
+func header(timestampMap string) string {
+	if timestampMap == "" {
+		return "WEBVTT\n\n"
+	}
+	return "WEBVTT\n" + timestampMap + "\n\n"
+}
+
+// FormatVTT renders cues as a WebVTT file.
+func FormatVTT(cues []Cue) []byte {
+	var b strings.Builder
+	b.WriteString(header(""))
+	writeCues(&b, cues)
+	return []byte(b.String())
+}
+
+func writeCues(b *strings.Builder, cues []Cue) {
+	for _, cue := range cues {
+		if cue.ID != "" {
+			b.WriteString(cue.ID + "\n")
+		}
+		fmt.Fprintf(b, "%s --> %s", formatTimestamp(cue.Start), formatTimestamp(cue.End))
+		if cue.Settings != "" {
+			b.WriteString(" " + cue.Settings)
+		}
+		b.WriteString("\n" + cue.Text + "\n\n")
+	}
+}
+
+// ValidLanguage accepts RFC 5646 tags of the shape used for captions, e.g.
+// "en", "pt-BR", "zh-Hant". The tag also names the rendition directory, so
+// nothing else is allowed.
+func ValidLanguage(tag string) bool {
+	parts := strings.Split(tag, "-")
+	if len(parts[0]) < 2 || len(parts[0]) > 3 || !isAlnum(parts[0], false) {
+		return false
+	}
+	for _, part := range parts[1:] {
+		if len(part) == 0 || len(part) > 8 || !isAlnum(part, true) {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlnum(s string, digits bool) bool {
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z':
+		case digits && r >= '0' && r <= '9':
+		default:
+			return false
+		}
+	}
+	return true
+}
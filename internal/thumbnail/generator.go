@@ -3,10 +3,14 @@ package thumbnail
 import (
 	"context"
 	"fmt"
+	"image/jpeg"
+	"math"
 	"os"
 	"path/filepath"
+	"strconv"
 	"upload/internal/config"
 	"upload/internal/exec"
+	"upload/internal/meta"
 )
 
 type Generator struct {
@@ -17,8 +21,9 @@ type Generator struct {
 type Options struct {
 	Count    int     // Number of thumbnails to generate
 	Interval float64 // Interval between thumbnails in seconds (0 = auto)
-	Width    int     // Thumbnail width (height auto-calculated)
+	Width    int     // Long side of the thumbnail (the other side keeps the aspect ratio)
 	Quality  int     // JPEG quality (1-31, lower is better)
+	Root     string  // Storage root of the video's tenant (empty = StorageDir)
 }
 
 func NewGenerator(config config.Config, runner exec.Runner) *Generator {
@@ -37,10 +42,17 @@ func DefaultOptions() Options {
 	}
 }
 
-func (generator *Generator) GenerateThumbnails(context context.Context, videoID string, inputPath string, duration float64, options Options) ([]string, error) {
-	thumbDir := filepath.Join(generator.config.StorageDir, "thumbnails", videoID)
+// GenerateThumbnails grabs Count evenly spaced stills and a poster from the
+// first scene change. The poster falls back to the first still when the
+// video has no scene change. On error the stills made so far are returned.
+func (generator *Generator) GenerateThumbnails(context context.Context, videoID string, inputPath string, duration float64, options Options) (meta.Thumbnails, error) {
+	root := options.Root
+	if root == "" {
+		root = generator.config.StorageDir
+	}
+	thumbDir := filepath.Join(root, "thumbnails", videoID)
 	if err := os.MkdirAll(thumbDir, 0755); err != nil {
-		return nil, fmt.Errorf("create thumbnail dir: %w", err)
+		return meta.Thumbnails{}, fmt.Errorf("create thumbnail dir: %w", err)
 	}
 
 	if options.Count <= 0 {
@@ -61,7 +73,7 @@ func (generator *Generator) GenerateThumbnails(context context.Context, videoID
 		interval = duration / float64(options.Count+1)
 	}
 
-	var thumbnails []string
+	thumbnails := meta.Thumbnails{Images: []meta.Thumbnail{}}
 
 	// Generate thumbnails at intervals
 	for i := 0; i < options.Count; i++ {
@@ -70,14 +82,15 @@ func (generator *Generator) GenerateThumbnails(context context.Context, videoID
 			timestamp = duration * (float64(i) / float64(options.Count))
 		}
 
-		outputPath := filepath.Join(thumbDir, fmt.Sprintf("thumb_%03d.jpg", i+1))
+		name := fmt.Sprintf("thumb_%03d.jpg", i+1)
+		outputPath := filepath.Join(thumbDir, name)
 
 		args := []string{
 			"-y",
 			"-ss", fmt.Sprintf("%.2f", timestamp),
 			"-i", inputPath,
 			"-vframes", "1",
-			"-vf", fmt.Sprintf("scale=%d:-1", options.Width),
+			"-vf", fitScale(options.Width),
 			"-q:v", fmt.Sprintf("%d", options.Quality),
 			outputPath,
 		}
@@ -86,48 +99,114 @@ func (generator *Generator) GenerateThumbnails(context context.Context, videoID
 			return thumbnails, fmt.Errorf("generate thumbnail %d at %.2fs: %w", i+1, timestamp, err)
 		}
 
-		thumbnails = append(thumbnails, fmt.Sprintf("thumbnails/%s/thumb_%03d.jpg", videoID, i+1))
+		thumb, err := describe(outputPath, fmt.Sprintf("thumbnails/%s/%s", videoID, name), timestamp)
+		if err != nil {
+			return thumbnails, fmt.Errorf("generate thumbnail %d at %.2fs: %w", i+1, timestamp, err)
+		}
+		thumbnails.Images = append(thumbnails.Images, thumb)
 	}
 
-	// Also generate a poster image from the first interesting frame
+	// Also generate a poster image from the first interesting frame;
+	// showinfo reports where it was taken
 	posterPath := filepath.Join(thumbDir, "poster.jpg")
+	os.Remove(posterPath)
 	posterArgs := []string{
 		"-y",
 		"-i", inputPath,
-		"-vf", fmt.Sprintf("select='gt(scene,0.4)',scale=%d:-1", options.Width*2),
+		"-vf", "select='gt(scene,0.4)'," + fitScale(options.Width*2) + ",showinfo",
 		"-frames:v", "1",
 		"-q:v", fmt.Sprintf("%d", options.Quality),
 		posterPath,
 	}
 
-	if _, err := generator.runner.Run(context, generator.config.FFmpegPath, posterArgs...); err != nil {
-		thumbnails = append(thumbnails, fmt.Sprintf("thumbnails/%s/poster.jpg", videoID))
+	output, err := generator.runner.Run(context, generator.config.FFmpegPath, posterArgs...)
+	if err == nil {
+		var timestamp float64
+		if match := ptsTimePattern.FindSubmatch(output); match != nil {
+			timestamp, _ = strconv.ParseFloat(string(match[1]), 64)
+		}
+		// without a scene change ffmpeg succeeds but writes nothing
+		if poster, err := describe(posterPath, fmt.Sprintf("thumbnails/%s/poster.jpg", videoID), timestamp); err == nil {
+			thumbnails.Poster = &poster
+		}
+	}
+	if thumbnails.Poster == nil && len(thumbnails.Images) > 0 {
+		poster := thumbnails.Images[0]
+		thumbnails.Poster = &poster
 	}
 
 	return thumbnails, nil
 }
 
-func (generator *Generator) GenerateSingleThumbnail(context context.Context, videoID string, inputPath string, timestamp float64) (string, error) {
-	thumbDir := filepath.Join(generator.config.StorageDir, "thumbnails", videoID)
-	if err := os.MkdirAll(thumbDir, 0755); err != nil {
-		return "", fmt.Errorf("create thumbnail dir: %w", err)
+// describe reads the size of a generated JPEG.
+func describe(file, publicPath string, timestamp float64) (meta.Thumbnail, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return meta.Thumbnail{}, err
+	}
+	defer f.Close()
+	size, err := jpeg.DecodeConfig(f)
+	if err != nil {
+		return meta.Thumbnail{}, fmt.Errorf("read %s: %w", filepath.Base(file), err)
 	}
 
-	outputPath := filepath.Join(thumbDir, "preview.jpg")
+	return meta.Thumbnail{
+		Path:      publicPath,
+		Timestamp: math.Round(timestamp*1000) / 1000,
+		Width:     size.Width,
+		Height:    size.Height,
+	}, nil
+}
 
-	args := []string{
-		"-y",
-		"-ss", fmt.Sprintf("%.2f", timestamp),
+// Frame formats ExtractFrame can write, by the format name clients ask for.
+var FrameFormats = map[string]string{
+	"jpeg": ".jpg",
+	"webp": ".webp",
+	"png":  ".png",
+}
+
+// ExtractFrame writes the frame at timestamp, width px wide, to outputPath in
+// one of FrameFormats. inputPath may be the original, an MP4 rendition or a
+// local HLS playlist.
+func (generator *Generator) ExtractFrame(context context.Context, inputPath, outputPath string, timestamp float64, width int, format string) error {
+	args := []string{"-y"}
+	if filepath.Ext(inputPath) == ".m3u8" {
+		// fMP4 segments (.m4s) are refused by default
+		args = append(args, "-allowed_extensions", "ALL")
+	}
+	args = append(args,
+		"-ss", fmt.Sprintf("%.3f", timestamp),
 		"-i", inputPath,
-		"-vframes", "1",
-		"-vf", "scale=640:-1",
-		"-q:v", "2",
-		outputPath,
+		"-frames:v", "1",
+		"-an", "-sn",
+		"-vf", fmt.Sprintf("scale=%d:-2", width),
+	)
+	switch format {
+	case "jpeg":
+		args = append(args, "-q:v", "3")
+	case "webp":
+		args = append(args, "-c:v", "libwebp", "-quality", "80")
+	case "png":
+	default:
+		return fmt.Errorf("unsupported frame format %q", format)
 	}
+	args = append(args, outputPath)
 
 	if _, err := generator.runner.Run(context, generator.config.FFmpegPath, args...); err != nil {
-		return "", fmt.Errorf("generate thumbnail at %.2fs: %w", timestamp, err)
+		return fmt.Errorf("extract frame at %.3fs: %w", timestamp, err)
+	}
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		// past the last frame ffmpeg succeeds without writing anything
+		return fmt.Errorf("no frame at %.3fs", timestamp)
 	}
 
-	return fmt.Sprintf("thumbnails/%s/preview.jpg", videoID), nil
+	return nil
+}
+
+// fitScale scales the long side to size whatever the orientation, so portrait
+// frames fit the same box as landscape ones instead of coming out size px wide
+// and much taller. ffmpeg autorotates before the filter graph, so iw/ih are
+// the displayed dimensions.
+func fitScale(size int) string {
+	return fmt.Sprintf("scale='if(gte(iw,ih),%[1]d,-2)':'if(gte(iw,ih),-2,%[1]d)'", size)
 }
@@ -0,0 +1,241 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"upload/internal/meta"
+)
+
+type PreviewOptions struct {
+	Snippets        int     // Number of snippets joined into the preview
+	SnippetDuration float64 // Seconds per snippet
+	MaxDuration     float64 // Cap on the whole preview; snippets get shorter to fit
+	SceneDetect     bool    // Start snippets at scene changes instead of evenly spaced
+	Width           int     // Long side of the preview
+	FPS             int
+	MaxBytes        int64 // Cap per file; larger previews are re-encoded smaller
+	DisplayWidth    int   // Displayed size of the source, for the recorded preview size
+	DisplayHeight   int
+	Root            string // Storage root of the video's tenant (empty = StorageDir)
+}
+
+func DefaultPreviewOptions() PreviewOptions {
+	return PreviewOptions{
+		Snippets:        5,
+		SnippetDuration: 1,
+		MaxDuration:     6,
+		Width:           320,
+		FPS:             12,
+		MaxBytes:        1 << 20,
+	}
+}
+
+// minPreviewWidth is where shrinking an oversized preview gives up.
+const minPreviewWidth = 96
+
+var ptsTimePattern = regexp.MustCompile(`pts_time:([0-9.]+)`)
+
+// GeneratePreview joins short silent snippets of the video into an animated
+// WebP and a tiny MP4 for hover previews in video grids.
+func (generator *Generator) GeneratePreview(context context.Context, videoID string, inputPath string, duration float64, options PreviewOptions) (meta.AnimatedPreview, error) {
+	if duration <= 0 {
+		return meta.AnimatedPreview{}, errors.New("unknown duration")
+	}
+	defaults := DefaultPreviewOptions()
+	if options.Snippets <= 0 {
+		options.Snippets = defaults.Snippets
+	}
+	if options.SnippetDuration <= 0 {
+		options.SnippetDuration = defaults.SnippetDuration
+	}
+	if options.MaxDuration <= 0 {
+		options.MaxDuration = defaults.MaxDuration
+	}
+	if options.Width <= 0 {
+		options.Width = defaults.Width
+	}
+	if options.FPS <= 0 {
+		options.FPS = defaults.FPS
+	}
+
+	root := options.Root
+	if root == "" {
+		root = generator.config.StorageDir
+	}
+	thumbDir := filepath.Join(root, "thumbnails", videoID)
+	if err := os.MkdirAll(thumbDir, 0755); err != nil {
+		return meta.AnimatedPreview{}, fmt.Errorf("create thumbnail dir: %w", err)
+	}
+
+	// short videos get fewer snippets rather than overlapping ones
+	snippet := min(options.SnippetDuration, options.MaxDuration/float64(options.Snippets))
+	count := max(1, min(options.Snippets, int(duration/snippet)))
+	snippet = min(snippet, duration)
+
+	var starts []float64
+	if options.SceneDetect {
+		scenes, err := generator.sceneChanges(context, inputPath)
+		if err != nil {
+			return meta.AnimatedPreview{}, err
+		}
+		starts = pickStarts(scenes, evenStarts(duration, snippet, count), duration, snippet, count)
+	} else {
+		starts = evenStarts(duration, snippet, count)
+	}
+
+	preview := meta.AnimatedPreview{
+		WebP:        fmt.Sprintf("thumbnails/%s/preview.webp", videoID),
+		MP4:         fmt.Sprintf("thumbnails/%s/preview.mp4", videoID),
+		DurationSec: snippet * float64(len(starts)),
+		Snippets:    starts,
+	}
+	webpPath := filepath.Join(thumbDir, "preview.webp")
+	mp4Path := filepath.Join(thumbDir, "preview.mp4")
+
+	// re-encode at three quarters of the size until both files fit MaxBytes
+	for width := options.Width; ; width = width * 3 / 4 &^ 1 {
+		if width < minPreviewWidth {
+			os.Remove(webpPath)
+			os.Remove(mp4Path)
+			return meta.AnimatedPreview{}, fmt.Errorf("preview does not fit %d bytes", options.MaxBytes)
+		}
+
+		args := []string{"-y"}
+		var filter string
+		for i, start := range starts {
+			args = append(args,
+				"-ss", fmt.Sprintf("%.3f", start),
+				"-t", fmt.Sprintf("%.3f", snippet),
+				"-i", inputPath,
+			)
+			filter += fmt.Sprintf("[%d:v:0]setpts=PTS-STARTPTS,fps=%d,%s,setsar=1[s%d];", i, options.FPS, fitScale(width), i)
+		}
+		for i := range starts {
+			filter += fmt.Sprintf("[s%d]", i)
+		}
+		filter += fmt.Sprintf("concat=n=%d:v=1:a=0,split=2[webp][mp4]", len(starts))
+
+		args = append(args,
+			"-filter_complex", filter,
+			"-map", "[webp]", "-an",
+			"-c:v", "libwebp", "-loop", "0", "-q:v", "60", "-compression_level", "4",
+			webpPath,
+			"-map", "[mp4]", "-an",
+			"-c:v", "libx264", "-preset", "veryfast", "-crf", "30", "-pix_fmt", "yuv420p",
+			"-movflags", "+faststart",
+			mp4Path,
+		)
+		if _, err := generator.runner.Run(context, generator.config.FFmpegPath, args...); err != nil {
+			return meta.AnimatedPreview{}, fmt.Errorf("generate preview: %w", err)
+		}
+
+		webp, err := os.Stat(webpPath)
+		if err != nil {
+			return meta.AnimatedPreview{}, err
+		}
+		mp4, err := os.Stat(mp4Path)
+		if err != nil {
+			return meta.AnimatedPreview{}, err
+		}
+		preview.WebPBytes = webp.Size()
+		preview.MP4Bytes = mp4.Size()
+		preview.Width, preview.Height = fitSize(width, options.DisplayWidth, options.DisplayHeight)
+		if options.MaxBytes <= 0 || max(preview.WebPBytes, preview.MP4Bytes) <= options.MaxBytes {
+			return preview, nil
+		}
+	}
+}
+
+// sceneChanges lists the times of scene cuts, detected on a downscaled
+// decode to keep the pass cheap.
+func (generator *Generator) sceneChanges(context context.Context, inputPath string) ([]float64, error) {
+	args := []string{
+		"-i", inputPath,
+		"-an", "-sn",
+		"-vf", "scale=160:-2,select='gt(scene,0.3)',showinfo",
+		"-f", "null", "-",
+	}
+	output, err := generator.runner.Run(context, generator.config.FFmpegPath, args...)
+	if err != nil {
+		return nil, fmt.Errorf("detect scenes: %w", err)
+	}
+
+	var scenes []float64
+	for _, match := range ptsTimePattern.FindAllSubmatch(output, -1) {
+		if t, err := strconv.ParseFloat(string(match[1]), 64); err == nil {
+			scenes = append(scenes, t)
+		}
+	}
+	return scenes, nil
+}
+
+// evenStarts spreads count snippets over the video, skipping the very start
+// and end, which are often black or titles.
+func evenStarts(duration, snippet float64, count int) []float64 {
+	starts := make([]float64, count)
+	for i := range starts {
+		center := duration * float64(i+1) / float64(count+1)
+		starts[i] = max(0, min(center-snippet/2, duration-snippet))
+	}
+	return starts
+}
+
+// pickStarts chooses count snippet starts among scene cuts, evenly over the
+// list, topping up with fallback starts when the video has too few cuts.
+func pickStarts(scenes, fallback []float64, duration, snippet float64, count int) []float64 {
+	var usable []float64
+	for _, t := range scenes {
+		if t+snippet <= duration {
+			usable = append(usable, t)
+		}
+	}
+
+	var starts []float64
+	if len(usable) >= count {
+		for i := range count {
+			starts = append(starts, usable[i*len(usable)/count])
+		}
+		return starts
+	}
+
+	starts = append(starts, usable...)
+	for _, t := range fallback {
+		if len(starts) == count {
+			break
+		}
+		if !overlaps(starts, t, snippet) {
+			starts = append(starts, t)
+		}
+	}
+	slices.Sort(starts)
+	return starts
+}
+
+func overlaps(starts []float64, t, snippet float64) bool {
+	for _, start := range starts {
+		if t < start+snippet && start < t+snippet {
+			return true
+		}
+	}
+	return false
+}
+
+// fitSize is the output size of fitScale(size) for a width x height source,
+// with ffmpeg's rounding of the -2 side; 0, 0 when the source size is
+// unknown.
+func fitSize(size, width, height int) (int, int) {
+	if width <= 0 || height <= 0 {
+		return 0, 0
+	}
+	if width >= height {
+		return size, (size*height + width) / (2 * width) * 2
+	}
+	return (size*width + height) / (2 * height) * 2, size
+}
@@ -0,0 +1,146 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	"math"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+
+	"upload/internal/meta"
+	"upload/internal/subtitle"
+)
+
+type SpriteOptions struct {
+	Interval float64 // Seconds between tiles
+	Width    int     // Tile width (the height keeps the aspect ratio)
+	Columns  int     // Tiles per row of a sheet
+	Rows     int     // Rows per sheet
+	Quality  int     // JPEG quality (1-31, lower is better)
+	Root     string  // Storage root of the video's tenant (empty = StorageDir)
+}
+
+func DefaultSpriteOptions() SpriteOptions {
+	return SpriteOptions{
+		Interval: 10,
+		Width:    160,
+		Columns:  10,
+		Rows:     10,
+		Quality:  5,
+	}
+}
+
+// GenerateSprites tiles a frame every Interval seconds into sprite_NNN.jpg
+// sheets and writes sprites.vtt, whose cues map each time range to its tile
+// as sprite_NNN.jpg#xywh=x,y,w,h (relative to the VTT file).
+func (generator *Generator) GenerateSprites(context context.Context, videoID string, inputPath string, duration float64, options SpriteOptions) (meta.SpriteSheets, error) {
+	if duration <= 0 {
+		return meta.SpriteSheets{}, errors.New("unknown duration")
+	}
+	defaults := DefaultSpriteOptions()
+	if options.Interval <= 0 {
+		options.Interval = defaults.Interval
+	}
+	if options.Width <= 0 {
+		options.Width = defaults.Width
+	}
+	if options.Columns <= 0 {
+		options.Columns = defaults.Columns
+	}
+	if options.Rows <= 0 {
+		options.Rows = defaults.Rows
+	}
+	if options.Quality <= 0 || options.Quality > 31 {
+		options.Quality = defaults.Quality
+	}
+
+	root := options.Root
+	if root == "" {
+		root = generator.config.StorageDir
+	}
+	thumbDir := filepath.Join(root, "thumbnails", videoID)
+	if err := os.MkdirAll(thumbDir, 0755); err != nil {
+		return meta.SpriteSheets{}, fmt.Errorf("create thumbnail dir: %w", err)
+	}
+
+	// a reprocess with other settings may produce fewer sheets
+	old, _ := filepath.Glob(filepath.Join(thumbDir, "sprite_*.jpg"))
+	for _, p := range append(old, filepath.Join(thumbDir, "sprites.vtt")) {
+		os.Remove(p)
+	}
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-an", "-sn",
+		"-vf", fmt.Sprintf("fps=1/%g,scale=%d:-2,tile=%dx%d", options.Interval, options.Width, options.Columns, options.Rows),
+		"-q:v", fmt.Sprintf("%d", options.Quality),
+		filepath.Join(thumbDir, "sprite_%03d.jpg"),
+	}
+	if _, err := generator.runner.Run(context, generator.config.FFmpegPath, args...); err != nil {
+		return meta.SpriteSheets{}, fmt.Errorf("generate sprites: %w", err)
+	}
+
+	sheets, _ := filepath.Glob(filepath.Join(thumbDir, "sprite_*.jpg"))
+	slices.Sort(sheets)
+	if len(sheets) == 0 {
+		return meta.SpriteSheets{}, errors.New("ffmpeg produced no sprite sheets")
+	}
+
+	// the tile filter always emits full sheets, so the first one gives the
+	// tile size whatever the aspect ratio and rotation
+	f, err := os.Open(sheets[0])
+	if err != nil {
+		return meta.SpriteSheets{}, err
+	}
+	size, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return meta.SpriteSheets{}, fmt.Errorf("read sprite sheet: %w", err)
+	}
+
+	sprites := meta.SpriteSheets{
+		VTT:        fmt.Sprintf("thumbnails/%s/sprites.vtt", videoID),
+		Interval:   options.Interval,
+		TileWidth:  size.Width / options.Columns,
+		TileHeight: size.Height / options.Rows,
+		Columns:    options.Columns,
+		Rows:       options.Rows,
+	}
+	for _, sheet := range sheets {
+		sprites.Images = append(sprites.Images, fmt.Sprintf("thumbnails/%s/%s", videoID, filepath.Base(sheet)))
+	}
+
+	var cues []subtitle.Cue
+	perSheet := options.Columns * options.Rows
+	tiles := int(math.Ceil(duration / options.Interval))
+	for i := 0; i < tiles && i/perSheet < len(sheets); i++ {
+		tile := i % perSheet
+		cues = append(cues, subtitle.Cue{
+			Start: seconds(float64(i) * options.Interval),
+			End:   seconds(min(float64(i+1)*options.Interval, duration)),
+			Text: fmt.Sprintf("%s#xywh=%d,%d,%d,%d", filepath.Base(sheets[i/perSheet]),
+				tile%options.Columns*sprites.TileWidth, tile/options.Columns*sprites.TileHeight,
+				sprites.TileWidth, sprites.TileHeight),
+		})
+	}
+
+	vttPath := filepath.Join(thumbDir, "sprites.vtt")
+	if err := os.WriteFile(vttPath+".tmp", subtitle.FormatVTT(cues), 0644); err != nil {
+		return meta.SpriteSheets{}, err
+	}
+	if err := os.Rename(vttPath+".tmp", vttPath); err != nil {
+		return meta.SpriteSheets{}, err
+	}
+
+	return sprites, nil
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,132 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"upload/internal/hls"
+	"upload/internal/meta"
+)
+
+const (
+	audioDir = "audio"
+	// audioGroup holds one rendition per source track; video variants
+	// reference it with AUDIO="aud".
+	audioGroup = "aud"
+	// lowAudioGroup is the low-bitrate audio-only rendition, listed as its
+	// own variant stream for listeners on poor connections.
+	lowAudioGroup = "aud-low"
+)
+
+// separateAudio reports whether audio is packaged as standalone HLS
+// renditions (AUDIO_MODE=separate) instead of being muxed into every video
+// rendition.
+func (transcoder *Transcoder) separateAudio() bool {
+	return transcoder.config.AudioMode == "separate"
+}
+
+// encodeAudioRenditions writes one HLS audio rendition per source track, plus
+// a low-bitrate audio-only rendition of the default track when configured.
+func (transcoder *Transcoder) encodeAudioRenditions(context context.Context, inputPath, outputDir string, metadata meta.Metadata, container string) ([]meta.Variant, error) {
+	tracks := metadata.AudioTracks
+	if len(tracks) == 0 {
+		return nil, nil
+	}
+
+	defaultIndex := 0
+	for i, track := range tracks {
+		if track.Default {
+			defaultIndex = i
+			break
+		}
+	}
+
+	var variants []meta.Variant
+	for i, track := range tracks {
+		v := meta.Variant{
+			Format:      "hls",
+			Kind:        "audio",
+			Codec:       "aac",
+			Group:       audioGroup,
+			Name:        trackName(track),
+			Language:    hls.Language(track.Language),
+			Channels:    2,
+			Default:     i == defaultIndex,
+			BitrateKbps: parseBitrate(transcoder.config.AudioBitrate),
+			PathOrPl:    fmt.Sprintf("%s/a%d/index.m3u8", audioDir, track.Index),
+			Container:   container,
+		}
+		if err := transcoder.encodeAudio(context, inputPath, outputDir, track.Index, transcoder.config.AudioBitrate, v); err != nil {
+			return nil, fmt.Errorf("audio track %d: %w", track.Index, err)
+		}
+		variants = append(variants, v)
+	}
+
+	if transcoder.config.AudioOnlyBitrate != "" {
+		track := tracks[defaultIndex]
+		v := meta.Variant{
+			Format:      "hls",
+			Kind:        "audio",
+			Codec:       "aac",
+			Group:       lowAudioGroup,
+			Name:        "Audio only",
+			Language:    hls.Language(track.Language),
+			Channels:    2,
+			BitrateKbps: parseBitrate(transcoder.config.AudioOnlyBitrate),
+			PathOrPl:    audioDir + "/low/index.m3u8",
+			Container:   container,
+		}
+		if err := transcoder.encodeAudio(context, inputPath, outputDir, track.Index, transcoder.config.AudioOnlyBitrate, v); err != nil {
+			return nil, fmt.Errorf("audio-only rendition: %w", err)
+		}
+		variants = append(variants, v)
+	}
+
+	for i := range variants {
+		if err := transcoder.measureVariant(context, outputDir, &variants[i]); err != nil {
+			return nil, fmt.Errorf("measure %s: %w", variants[i].PathOrPl, err)
+		}
+	}
+
+	return variants, nil
+}
+
+func (transcoder *Transcoder) encodeAudio(context context.Context, inputPath, outputDir string, trackIndex int, bitrate string, v meta.Variant) error {
+	playlistPath := filepath.Join(outputDir, filepath.FromSlash(v.PathOrPl))
+	dir := filepath.Dir(playlistPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-map", fmt.Sprintf("0:a:%d", trackIndex),
+		"-vn",
+		"-c:a", "aac",
+		"-b:a", bitrate,
+		"-ac", "2",
+		"-f", "hls",
+		"-hls_time", "4",
+		"-hls_playlist_type", "vod",
+	}
+	args = append(args, segmentArgs(dir, v.Container)...)
+	args = append(args, playlistPath)
+
+	_, err := transcoder.runner.Run(context, transcoder.config.FFmpegPath, args...)
+	return err
+}
+
+// trackName is the NAME shown in players' audio menus.
+func trackName(track meta.AudioTrack) string {
+	if track.Title != "" {
+		return track.Title
+	}
+	if lang := hls.Language(track.Language); lang != "" {
+		return strings.ToUpper(lang)
+	}
+	return fmt.Sprintf("Track %d", track.Index+1)
+}
@@ -0,0 +1,251 @@
+package transcoder
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"upload/internal/exec"
+)
+
+// encoderProfile describes how to drive one ffmpeg video encoder.
+type encoderProfile struct {
+	codec string // short name used in directory names and meta.Variant.Codec
+	// bitrateFactor scales the H.264 ladder bitrate for the same height
+	bitrateFactor float64
+	// fmp4 encoders can't be carried in MPEG-TS HLS segments
+	fmp4 bool
+	// rateControl reports whether -maxrate/-bufsize are honored
+	rateControl bool
+	// options are encoder specific flags; spec is "" or a stream specifier
+	// such as ":v:1" when several outputs share one ffmpeg
+	options func(spec string) []string
+}
+
+const defaultEncoder = "libx264"
+
+var encoderProfiles = map[string]encoderProfile{
+	"libx264": {
+		codec: "h264", bitrateFactor: 1, rateControl: true,
+		options: func(spec string) []string {
+			return []string{
+				"-preset" + spec, "ultrafast", // 더 빠른 인코딩
+				"-profile" + spec, "main",
+				"-sc_threshold" + spec, "0",
+			}
+		},
+	},
+	"libx265": {
+		codec: "hevc", bitrateFactor: 0.6, fmp4: true, rateControl: true,
+		options: func(spec string) []string {
+			return []string{
+				"-preset" + spec, "ultrafast",
+				"-tag" + spec, "hvc1", // Apple players only accept hvc1
+				"-x265-params" + spec, "scenecut=0:open-gop=0",
+			}
+		},
+	},
+	"libvpx-vp9": {
+		codec: "vp9", bitrateFactor: 0.65, fmp4: true, rateControl: true,
+		options: func(spec string) []string {
+			return []string{
+				"-deadline" + spec, "realtime",
+				"-cpu-used" + spec, "8",
+				"-row-mt" + spec, "1",
+			}
+		},
+	},
+	"libsvtav1": {
+		codec: "av1", bitrateFactor: 0.5, fmp4: true,
+		options: func(spec string) []string {
+			return []string{"-preset" + spec, "10"}
+		},
+	},
+	"libaom-av1": {
+		codec: "av1", bitrateFactor: 0.5, fmp4: true, rateControl: true,
+		options: func(spec string) []string {
+			return []string{
+				"-cpu-used" + spec, "8",
+				"-row-mt" + spec, "1",
+			}
+		},
+	},
+}
+
+func (res Resolution) encoderName() string {
+	if res.Encoder == "" {
+		return defaultEncoder
+	}
+	return res.Encoder
+}
+
+func (res Resolution) profile() encoderProfile {
+	return encoderProfiles[res.encoderName()]
+}
+
+// Codec is the short codec name (h264, hevc, vp9, av1).
+func (res Resolution) Codec() string {
+	return res.profile().codec
+}
+
+// Dir is the rendition's directory under outputs/<id>. H.264 keeps the plain
+// height so existing URLs don't change.
+func (res Resolution) Dir() string {
+	if res.Codec() == "h264" {
+		return strconv.Itoa(res.Height)
+	}
+	return fmt.Sprintf("%d_%s", res.Height, res.Codec())
+}
+
+// Label is used in logs and error messages, e.g. "720p" or "720p hevc".
+func (res Resolution) Label() string {
+	if res.Codec() == "h264" {
+		return fmt.Sprintf("%dp", res.Height)
+	}
+	return fmt.Sprintf("%dp %s", res.Height, res.Codec())
+}
+
+// videoArgs returns the encoder, rate control and GOP flags for res.
+func videoArgs(res Resolution, spec string) []string {
+	profile := res.profile()
+	args := []string{
+		"-c" + spec, res.encoderName(),
+		"-b" + spec, res.VideoBitrate,
+		"-g" + spec, "48",
+		"-keyint_min" + spec, "48",
+	}
+	if profile.rateControl {
+		args = append(args, "-maxrate"+spec, res.MaxRate, "-bufsize"+spec, res.BufSize)
+	}
+
+	return append(args, profile.options(spec)...)
+}
+
+// parseLadder reads LADDER entries of the form height[:encoder[:bitrate]],
+// e.g. "480,720,1080,720:libx265,1080:libsvtav1:900k". Bitrates default to
+// the H.264 ladder scaled by the encoder's efficiency. A "mode=single-pass"
+// (or "mode=per-rendition", the default) entry picks how the ladder is
+// encoded; on its own it applies to the built-in rungs.
+func parseLadder(spec string) (Ladder, error) {
+	ladder := Ladder{Mode: ModePerRendition}
+	modeSet := false
+	seen := map[string]bool{}
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if value, ok := strings.CutPrefix(entry, "mode="); ok {
+			mode := EncodeMode(value)
+			if mode != ModePerRendition && mode != ModeSinglePass {
+				return Ladder{}, fmt.Errorf("ladder entry %q: mode must be %s or %s", entry, ModePerRendition, ModeSinglePass)
+			}
+			if modeSet {
+				return Ladder{}, fmt.Errorf("ladder entry %q: mode given twice", entry)
+			}
+			ladder.Mode, modeSet = mode, true
+			continue
+		}
+		parts := strings.Split(entry, ":")
+		height, err := strconv.Atoi(parts[0])
+		if err != nil || height <= 0 {
+			return Ladder{}, fmt.Errorf("ladder entry %q: bad height", entry)
+		}
+
+		res := nearestResolution(height)
+		res.Height = height
+		if len(parts) > 1 && parts[1] != "" {
+			if _, ok := encoderProfiles[parts[1]]; !ok {
+				return Ladder{}, fmt.Errorf("ladder entry %q: unsupported encoder %s", entry, parts[1])
+			}
+			res.Encoder = parts[1]
+		}
+
+		factor := res.profile().bitrateFactor
+		if len(parts) > 2 && parts[2] != "" {
+			kbps := parseBitrate(parts[2])
+			if kbps <= 0 {
+				return Ladder{}, fmt.Errorf("ladder entry %q: bad bitrate", entry)
+			}
+			factor = float64(kbps) / float64(parseBitrate(res.VideoBitrate))
+		}
+		res.VideoBitrate = scaleBitrate(res.VideoBitrate, factor)
+		res.MaxRate = scaleBitrate(res.MaxRate, factor)
+		res.BufSize = scaleBitrate(res.BufSize, factor)
+		// two rungs with one height and codec would write the same directory
+		if seen[res.Dir()] {
+			return Ladder{}, fmt.Errorf("ladder entry %q: %s is listed twice", entry, res.Label())
+		}
+		seen[res.Dir()] = true
+		ladder.Resolutions = append(ladder.Resolutions, res)
+	}
+	if len(ladder.Resolutions) == 0 {
+		if !modeSet {
+			return Ladder{}, fmt.Errorf("empty ladder")
+		}
+		ladder.Resolutions = resolutions
+	}
+
+	return ladder, nil
+}
+
+// nearestResolution returns the built-in rung closest to height, used as the
+// template for bitrates.
+func nearestResolution(height int) Resolution {
+	best := resolutions[0]
+	for _, res := range resolutions {
+		if abs(res.Height-height) < abs(best.Height-height) {
+			best = res
+		}
+	}
+	return best
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+func scaleBitrate(bitrate string, factor float64) string {
+	return fmt.Sprintf("%dk", int(float64(parseBitrate(bitrate))*factor))
+}
+
+var (
+	encodersMu    sync.Mutex
+	encodersCache = map[string]map[string]bool{}
+)
+
+// AvailableEncoders lists the encoders compiled into the ffmpeg binary
+// (`ffmpeg -encoders`). The result is cached per binary.
+func AvailableEncoders(context context.Context, runner exec.Runner, ffmpegPath string) (map[string]bool, error) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+
+	if encoders, ok := encodersCache[ffmpegPath]; ok {
+		return encoders, nil
+	}
+
+	output, err := runner.Run(context, ffmpegPath, "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, fmt.Errorf("list encoders: %w", err)
+	}
+
+	// lines look like " V....D libx264              libx264 H.264 / AVC ..."
+	encoders := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && len(fields[0]) == 6 && fields[0][0] == 'V' {
+			encoders[fields[1]] = true
+		}
+	}
+	encodersCache[ffmpegPath] = encoders
+
+	return encoders, nil
+}
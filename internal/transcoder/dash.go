@@ -0,0 +1,230 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"upload/internal/dash"
+	"upload/internal/hls"
+	"upload/internal/meta"
+)
+
+// dashDir holds the MPD and its fMP4 segments under outputs/<id>.
+const dashDir = "dash"
+
+// packageDASH repackages the finished HLS renditions into an MPEG-DASH MPD
+// with fMP4 segments. Nothing is re-encoded: each rendition's playlist is an
+// input and streams are copied. It returns one "dash" variant per rendition,
+// carrying over the measurements of the HLS variant it came from. muxedAudio
+// means the video renditions carry audio; separate audio renditions are
+// passed in hlsVariants with Kind "audio" and get an adaptation set each.
+func (transcoder *Transcoder) packageDASH(context context.Context, outputDir string, hlsVariants []meta.Variant, muxedAudio bool) ([]meta.Variant, error) {
+	dir := filepath.Join(outputDir, dashDir)
+	if err := os.RemoveAll(dir); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	args := []string{"-y"}
+	for _, v := range hlsVariants {
+		args = append(args, "-i", filepath.Join(outputDir, filepath.FromSlash(v.PathOrPl)))
+	}
+	// one adaptation set per codec; players pick the set they can decode
+	var sets []string
+	byCodec := map[string][]string{}
+	var audioSets []string
+	byTrack := map[string][]string{}
+	lowStream := ""
+	audioStreams := 0
+	for i, v := range hlsVariants {
+		// output stream index == position in hlsVariants
+		stream := fmt.Sprintf("%d", i)
+		if v.Kind == "audio" {
+			args = append(args, "-map", fmt.Sprintf("%d:a:0", i))
+			if v.Language != "" {
+				args = append(args, fmt.Sprintf("-metadata:s:a:%d", audioStreams), "language="+v.Language)
+			}
+			audioStreams++
+			if v.Group == lowAudioGroup {
+				lowStream = stream
+				continue
+			}
+			audioSets = append(audioSets, v.PathOrPl)
+			byTrack[v.PathOrPl] = []string{stream}
+			if v.Default {
+				audioSets[0], audioSets[len(audioSets)-1] = audioSets[len(audioSets)-1], audioSets[0]
+			}
+			continue
+		}
+		args = append(args, "-map", fmt.Sprintf("%d:v:0", i))
+		if _, ok := byCodec[v.Codec]; !ok {
+			sets = append(sets, v.Codec)
+		}
+		byCodec[v.Codec] = append(byCodec[v.Codec], stream)
+	}
+	if lowStream != "" && len(audioSets) > 0 {
+		// the low-bitrate rendition is another representation of the default track
+		byTrack[audioSets[0]] = append(byTrack[audioSets[0]], lowStream)
+	}
+	adaptationSets := make([]string, 0, len(sets)+len(audioSets)+1)
+	for i, codec := range sets {
+		adaptationSets = append(adaptationSets, fmt.Sprintf("id=%d,streams=%s", i, strings.Join(byCodec[codec], ",")))
+	}
+	for i, track := range audioSets {
+		adaptationSets = append(adaptationSets, fmt.Sprintf("id=%d,streams=%s", len(sets)+i, strings.Join(byTrack[track], ",")))
+	}
+	if audioStreams > 0 {
+		args = append(args, "-bsf:a", "aac_adtstoasc")
+	} else if muxedAudio {
+		// every rendition carries the same audio; the MPD needs it only once
+		args = append(args, "-map", "0:a:0", "-bsf:a", "aac_adtstoasc")
+		adaptationSets = append(adaptationSets, fmt.Sprintf("id=%d,streams=a", len(sets)))
+	}
+	args = append(args,
+		"-c", "copy",
+		"-f", "dash",
+		"-seg_duration", "4",
+		"-use_template", "1",
+		"-use_timeline", "1",
+		"-adaptation_sets", strings.Join(adaptationSets, " "),
+		"-init_seg_name", "init-$RepresentationID$.m4s",
+		"-media_seg_name", "chunk-$RepresentationID$-$Number%05d$.m4s",
+		filepath.Join(dir, "manifest.mpd"),
+	)
+
+	if _, err := transcoder.runner.Run(context, transcoder.config.FFmpegPath, args...); err != nil {
+		return nil, err
+	}
+
+	variants := make([]meta.Variant, 0, len(hlsVariants))
+	for i, v := range hlsVariants {
+		repackaged := v
+		repackaged.Format = "dash"
+		repackaged.PathOrPl = dashDir + "/manifest.mpd"
+		repackaged.SizeBytes = representationSize(dir, i)
+		repackaged.ReadyAtUnix = time.Now().Unix()
+		variants = append(variants, repackaged)
+	}
+
+	return variants, nil
+}
+
+// writeSharedMPD writes an MPD whose representations point straight at the
+// fMP4 segments of the HLS renditions, so HLS and DASH share one set of CMAF
+// media. Renditions carrying muxed audio are listed as muxed representations;
+// separate audio renditions get one audio adaptation set per track.
+func (transcoder *Transcoder) writeSharedMPD(outputDir string, metadata meta.Metadata) ([]meta.Variant, error) {
+	dir := filepath.Join(outputDir, dashDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	var sets, audioSets []*dash.AdaptationSet
+	byCodec := map[string]*dash.AdaptationSet{}
+	var defaultAudio *dash.AdaptationSet
+	var low *meta.Variant
+	var lowPlaylist *hls.MediaPlaylist
+	var hlsVariants []meta.Variant
+	for _, v := range metadata.Variants {
+		if v.Format != "hls" || v.Kind == "subtitles" {
+			continue
+		}
+		playlist, err := hls.ReadMediaPlaylist(filepath.Join(outputDir, filepath.FromSlash(v.PathOrPl)))
+		if err != nil {
+			return nil, fmt.Errorf("read %s: %w", v.PathOrPl, err)
+		}
+		var set *dash.AdaptationSet
+		switch {
+		case v.Kind == "audio" && v.Group == lowAudioGroup:
+			// added to the default track's set below
+			low = &v
+			lowPlaylist = playlist
+		case v.Kind == "audio":
+			set = &dash.AdaptationSet{ContentType: "audio", Lang: v.Language}
+			if v.Default {
+				defaultAudio = set
+			}
+			audioSets = append(audioSets, set)
+		default:
+			var ok bool
+			set, ok = byCodec[v.Codec]
+			if !ok {
+				set = &dash.AdaptationSet{ContentType: "video"}
+				byCodec[v.Codec] = set
+				sets = append(sets, set)
+			}
+		}
+		hlsVariants = append(hlsVariants, v)
+		if set == nil {
+			continue
+		}
+		set.Representations = append(set.Representations, dash.Representation{
+			ID:        path.Dir(v.PathOrPl),
+			BaseURL:   "../" + path.Dir(v.PathOrPl) + "/",
+			Bandwidth: v.BandwidthPeak,
+			Width:     v.Width,
+			Height:    v.EncodedHeight,
+			Codecs:    v.Codecs,
+			FrameRate: v.FrameRate,
+			InitURI:   playlist.InitURI,
+			Segments:  playlist.Segments,
+		})
+	}
+	if low != nil && defaultAudio != nil {
+		defaultAudio.Representations = append(defaultAudio.Representations, dash.Representation{
+			ID:        path.Dir(low.PathOrPl),
+			BaseURL:   "../" + path.Dir(low.PathOrPl) + "/",
+			Bandwidth: low.BandwidthPeak,
+			Codecs:    low.Codecs,
+			InitURI:   lowPlaylist.InitURI,
+			Segments:  lowPlaylist.Segments,
+		})
+	}
+
+	manifest := dash.Manifest{DurationSec: metadata.DurationSec}
+	for _, set := range append(sets, audioSets...) {
+		manifest.AdaptationSets = append(manifest.AdaptationSets, *set)
+	}
+	mpdPath := filepath.Join(dir, "manifest.mpd")
+	if err := manifest.WriteFile(mpdPath); err != nil {
+		return nil, err
+	}
+
+	variants := make([]meta.Variant, 0, len(hlsVariants))
+	for _, v := range hlsVariants {
+		shared := v
+		shared.Format = "dash"
+		shared.PathOrPl = dashDir + "/manifest.mpd"
+		shared.SizeBytes = 0 // the media is accounted to the HLS variant
+		shared.ReadyAtUnix = time.Now().Unix()
+		variants = append(variants, shared)
+	}
+
+	return variants, nil
+}
+
+// representationSize sums the init and media segments of one representation.
+func representationSize(dir string, representationID int) int64 {
+	var total int64
+	patterns := []string{
+		fmt.Sprintf("init-%d.m4s", representationID),
+		fmt.Sprintf("chunk-%d-*.m4s", representationID),
+	}
+	for _, pattern := range patterns {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil {
+				total += info.Size()
+			}
+		}
+	}
+
+	return total
+}
@@ -0,0 +1,97 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"upload/internal/meta"
+)
+
+// downloadDir holds the progressive MP4 files under outputs/<id>.
+const downloadDir = "download"
+
+// DownloadPath is the output-relative path of the MP4 for height.
+func DownloadPath(height int) string {
+	return fmt.Sprintf("%s/%dp.mp4", downloadDir, height)
+}
+
+// packageMP4 remuxes one HLS rendition per height into a faststart MP4.
+// Nothing is re-encoded. H.264 is preferred when a height exists in several
+// codecs since it plays everywhere. In separate audio mode the default audio
+// rendition is muxed back in.
+func (transcoder *Transcoder) packageMP4(context context.Context, outputDir string, variants []meta.Variant) ([]meta.Variant, error) {
+	var heights []int
+	byHeight := map[int]meta.Variant{}
+	var audio *meta.Variant
+	for _, v := range variants {
+		if v.Format != "hls" {
+			continue
+		}
+		switch v.Kind {
+		case "":
+			current, ok := byHeight[v.Height]
+			if !ok {
+				heights = append(heights, v.Height)
+			}
+			if !ok || (current.Codec != "h264" && v.Codec == "h264") {
+				byHeight[v.Height] = v
+			}
+		case "audio":
+			if v.Group == audioGroup && (audio == nil || v.Default) {
+				audio = &v
+			}
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(outputDir, downloadDir), 0755); err != nil {
+		return nil, err
+	}
+
+	var downloads []meta.Variant
+	for _, height := range heights {
+		v := byHeight[height]
+		dst := filepath.Join(outputDir, filepath.FromSlash(DownloadPath(height)))
+		args := []string{
+			"-y",
+			"-i", filepath.Join(outputDir, filepath.FromSlash(v.PathOrPl)),
+		}
+		if audio != nil {
+			args = append(args,
+				"-i", filepath.Join(outputDir, filepath.FromSlash(audio.PathOrPl)),
+				"-map", "0:v:0", "-map", "1:a:0",
+			)
+		} else {
+			args = append(args, "-map", "0:v:0", "-map", "0:a:0?")
+		}
+		args = append(args,
+			"-c", "copy",
+			"-bsf:a", "aac_adtstoasc", // ADTS in TS segments -> MP4
+			"-movflags", "+faststart", // moov 앞으로: 다운로드 중에도 재생 가능
+			"-f", "mp4",
+			dst+".tmp",
+		)
+		if _, err := transcoder.runner.Run(context, transcoder.config.FFmpegPath, args...); err != nil {
+			os.Remove(dst + ".tmp")
+			return nil, fmt.Errorf("%dp: %w", height, err)
+		}
+		if err := os.Rename(dst+".tmp", dst); err != nil {
+			return nil, err
+		}
+
+		download := v
+		download.Format = "mp4"
+		download.Container = "mp4"
+		download.PathOrPl = DownloadPath(height)
+		download.SizeBytes = 0
+		if info, err := os.Stat(dst); err == nil {
+			download.SizeBytes = info.Size()
+		}
+		download.ReadyAtUnix = time.Now().Unix()
+		downloads = append(downloads, download)
+	}
+
+	return downloads, nil
+}
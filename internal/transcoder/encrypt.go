@@ -0,0 +1,70 @@
+package transcoder
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"upload/internal/fsutil"
+	"upload/internal/hls"
+	"upload/internal/meta"
+)
+
+// encrypted reports whether segments are encrypted (HLS_ENCRYPTION=aes-128).
+func (transcoder *Transcoder) encrypted() bool {
+	return transcoder.config.Encryption == "aes-128"
+}
+
+// encryptRenditions encrypts the video and audio renditions with fresh keys.
+// All renditions share the keys so switching renditions needs no extra key
+// request; with rotation, key n covers segments [n*rotation, (n+1)*rotation).
+// Subtitles stay in the clear.
+func (transcoder *Transcoder) encryptRenditions(root, outputDir, videoID string, variants []meta.Variant) error {
+	var playlists []string
+	segments := 0
+	for _, v := range variants {
+		if v.Format != "hls" || v.Kind == "subtitles" {
+			continue
+		}
+		playlistPath := filepath.Join(outputDir, filepath.FromSlash(v.PathOrPl))
+		playlist, err := hls.ReadMediaPlaylist(playlistPath)
+		if err != nil {
+			return fmt.Errorf("read %s: %w", v.PathOrPl, err)
+		}
+		playlists = append(playlists, playlistPath)
+		segments = max(segments, len(playlist.Segments))
+	}
+
+	keysDir := fsutil.KeysDir(root, videoID)
+	if err := os.RemoveAll(keysDir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(keysDir, 0700); err != nil {
+		return err
+	}
+	keys := make([][]byte, hls.KeysNeeded(segments, transcoder.config.KeyRotation))
+	for n := range keys {
+		keys[n] = make([]byte, hls.KeySize)
+		if _, err := rand.Read(keys[n]); err != nil {
+			return err
+		}
+		if err := os.WriteFile(fsutil.KeyPath(root, videoID, n), keys[n], 0600); err != nil {
+			return err
+		}
+	}
+
+	options := hls.EncryptOptions{
+		Keys:     keys,
+		Rotation: transcoder.config.KeyRotation,
+		// absolute, so it resolves the same from /streams and /videos
+		KeyURI: func(n int) string { return fmt.Sprintf("/videos/%s/keys/%d", videoID, n) },
+	}
+	for _, playlistPath := range playlists {
+		if err := hls.EncryptMediaPlaylist(playlistPath, options); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,122 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"upload/internal/config"
+)
+
+type EncodeMode string
+
+const (
+	// ModePerRendition runs one ffmpeg per rendition, decoding the source each time.
+	ModePerRendition EncodeMode = "per-rendition"
+	// ModeSinglePass decodes once and splits the picture into every rendition
+	// inside one ffmpeg filter graph.
+	ModeSinglePass EncodeMode = "single-pass"
+)
+
+// Ladder is a set of renditions plus how they are encoded.
+type Ladder struct {
+	Mode        EncodeMode
+	Resolutions []Resolution
+}
+
+// LadderFor returns the ladder configured via LADDER, or the built-in H.264
+// ladder encoded per rendition when LADDER is empty.
+func LadderFor(config config.Config) (Ladder, error) {
+	if config.Ladder == "" {
+		return Ladder{Mode: ModePerRendition, Resolutions: resolutions}, nil
+	}
+	return parseLadder(config.Ladder)
+}
+
+// Usable drops renditions whose encoder isn't in available. If that would
+// leave nothing, the built-in H.264 ladder is used.
+func (ladder Ladder) Usable(available map[string]bool) (usable Ladder, skipped []Resolution) {
+	usable = Ladder{Mode: ladder.Mode}
+	for _, res := range ladder.Resolutions {
+		if available[res.encoderName()] {
+			usable.Resolutions = append(usable.Resolutions, res)
+		} else {
+			skipped = append(skipped, res)
+		}
+	}
+	if len(usable.Resolutions) == 0 {
+		usable.Resolutions = resolutions
+	}
+
+	return usable, skipped
+}
+
+// ladder resolves the configured ladder against the encoders this ffmpeg
+// actually has. If detection fails the ladder is used as configured.
+func (transcoder *Transcoder) ladder(context context.Context) (Ladder, error) {
+	ladder, err := LadderFor(transcoder.config)
+	if err != nil {
+		return Ladder{}, err
+	}
+	available, err := AvailableEncoders(context, transcoder.runner, transcoder.config.FFmpegPath)
+	if err != nil {
+		return ladder, nil
+	}
+	usable, _ := ladder.Usable(available)
+
+	return usable, nil
+}
+
+// encodeSinglePass emits every rendition's HLS playlist and segments from one
+// decode: split -> scale per output, with -var_stream_map naming each variant
+// stream after its directory so the on-disk layout matches per-rendition mode.
+func (transcoder *Transcoder) encodeSinglePass(context context.Context, inputPath, outputDir string, targets []Resolution, container string, portrait, hasAudio bool) error {
+	var graph strings.Builder
+	fmt.Fprintf(&graph, "[0:v]split=%d", len(targets))
+	for i := range targets {
+		fmt.Fprintf(&graph, "[s%d]", i)
+	}
+	for i, res := range targets {
+		fmt.Fprintf(&graph, ";[s%d]%s[v%d]", i, scaleFilter(res.Height, portrait), i)
+	}
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-filter_complex", graph.String(),
+	}
+	streamMap := make([]string, 0, len(targets))
+	for i, res := range targets {
+		args = append(args, "-map", fmt.Sprintf("[v%d]", i))
+		entry := fmt.Sprintf("v:%d", i)
+		if hasAudio {
+			args = append(args, "-map", "0:a:0")
+			entry += fmt.Sprintf(",a:%d", i)
+		}
+		streamMap = append(streamMap, fmt.Sprintf("%s,name:%s", entry, res.Dir()))
+	}
+
+	for i, res := range targets {
+		args = append(args, videoArgs(res, fmt.Sprintf(":v:%d", i))...)
+	}
+	if hasAudio {
+		args = append(args, "-c:a", "aac")
+		for i, res := range targets {
+			args = append(args, fmt.Sprintf("-b:a:%d", i), res.AudioBitrate)
+		}
+	}
+
+	args = append(args,
+		"-threads", "2",
+		"-f", "hls",
+		"-hls_time", "4",
+		"-hls_playlist_type", "vod",
+		"-var_stream_map", strings.Join(streamMap, " "),
+	)
+	args = append(args, segmentArgs(filepath.Join(outputDir, "%v"), container)...)
+	args = append(args, filepath.Join(outputDir, "%v", "index.m3u8"))
+
+	_, err := transcoder.runner.Run(context, transcoder.config.FFmpegPath, args...)
+	return err
+}
@@ -0,0 +1,71 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"upload/internal/hls"
+	"upload/internal/meta"
+)
+
+// measureVariant fills in the encoded dimensions, codecs, frame rate and
+// peak/average bitrates of a rendition from what is actually on disk, so the
+// master playlist doesn't have to guess.
+func (transcoder *Transcoder) measureVariant(context context.Context, outputDir string, variant *meta.Variant) error {
+	playlistPath := filepath.Join(outputDir, filepath.FromSlash(variant.PathOrPl))
+	playlist, err := hls.ReadMediaPlaylist(playlistPath)
+	if err != nil {
+		return fmt.Errorf("read playlist: %w", err)
+	}
+	if len(playlist.Segments) == 0 {
+		return fmt.Errorf("playlist %s has no segments", variant.PathOrPl)
+	}
+
+	dir := filepath.Dir(playlistPath)
+	var total int64
+	var duration float64
+	peak := 0
+	if playlist.InitURI != "" {
+		if info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(playlist.InitURI))); err == nil {
+			total += info.Size()
+		}
+	}
+	for _, segment := range playlist.Segments {
+		info, err := os.Stat(filepath.Join(dir, filepath.FromSlash(segment.URI)))
+		if err != nil {
+			return fmt.Errorf("stat segment: %w", err)
+		}
+		total += info.Size()
+		duration += segment.Duration
+		if segment.Duration > 0 {
+			if bps := int(float64(info.Size()*8) / segment.Duration); bps > peak {
+				peak = bps
+			}
+		}
+	}
+
+	// the hls demuxer reads the first segments (and init section) for us
+	info, err := transcoder.prober.ProbeVideo(context, playlistPath)
+	if err != nil {
+		return fmt.Errorf("probe rendition: %w", err)
+	}
+
+	variant.Width = info.Width
+	variant.EncodedHeight = info.Height
+	variant.FrameRate = info.FPS
+	variant.Codecs = hls.JoinCodecs(
+		hls.VideoCodec(info.CodecName, info.Profile, info.Level, info.Width, info.Height),
+		hls.AudioCodec(info.AudioCodec, info.AudioProfile),
+	)
+	variant.SizeBytes = total
+	variant.BandwidthPeak = peak
+	if duration > 0 {
+		variant.BandwidthAverage = int(float64(total*8) / duration)
+	}
+	variant.ReadyAtUnix = time.Now().Unix()
+
+	return nil
+}
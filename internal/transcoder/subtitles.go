@@ -0,0 +1,209 @@
+package transcoder
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"upload/internal/fsutil"
+	"upload/internal/meta"
+	"upload/internal/store"
+	"upload/internal/subtitle"
+)
+
+const (
+	subtitleDir = "subtitles"
+	// subtitleGroup is the EXT-X-MEDIA GROUP-ID video variants reference
+	// with SUBTITLES="subs".
+	subtitleGroup = "subs"
+	// mpegtsStart is where ffmpeg's mpegts muxer starts timestamps (1.4s at
+	// 90kHz); fMP4 output starts at 0.
+	mpegtsStart = 126000
+)
+
+// packageSubtitles segments every caption track of the video into an HLS
+// WebVTT rendition. A broken track is logged and left out rather than
+// failing the whole video.
+func (transcoder *Transcoder) packageSubtitles(context context.Context, inputPath, outputDir string, metadata meta.Metadata) []meta.Variant {
+	var variants []meta.Variant
+	for _, track := range metadata.SubtitleTracks {
+		cues, err := transcoder.subtitleCues(context, inputPath, outputDir, metadata, track)
+		if err != nil {
+			log.Printf("Skipping subtitle track %s of %s: %v", subtitleRendition(track), metadata.ID, err)
+			continue
+		}
+		v, err := transcoder.segmentSubtitles(outputDir, metadata, track, cues)
+		if err != nil {
+			log.Printf("Skipping subtitle track %s of %s: %v", subtitleRendition(track), metadata.ID, err)
+			continue
+		}
+		variants = append(variants, v)
+	}
+
+	return variants
+}
+
+// AddSubtitle stores an uploaded caption track for the video, replacing an
+// earlier upload for the same language. Ready videos get the rendition and
+// an updated master playlist right away; others pick it up when transcoding
+// finishes.
+func (transcoder *Transcoder) AddSubtitle(videoID string, track meta.SubtitleTrack, cues []subtitle.Cue) (meta.Metadata, error) {
+	metadata, err := transcoder.store.Get(videoID)
+	if err != nil {
+		return meta.Metadata{}, fmt.Errorf("get metadata: %w", err)
+	}
+
+	track.Source = "upload"
+	root := transcoder.root(metadata.Tenant)
+	sourcePath := fsutil.SubtitlePath(root, videoID, track.Language)
+	if err := os.MkdirAll(filepath.Dir(sourcePath), 0755); err != nil {
+		return meta.Metadata{}, err
+	}
+	if err := os.WriteFile(sourcePath+".tmp", subtitle.FormatVTT(cues), 0644); err != nil {
+		return meta.Metadata{}, err
+	}
+	if err := os.Rename(sourcePath+".tmp", sourcePath); err != nil {
+		return meta.Metadata{}, err
+	}
+
+	// 처리 중인 파이프라인이나 PATCH 와 겹쳐도 최신 레코드에 트랙을 더함
+	return transcoder.store.Modify(videoID, func(metadata *meta.Metadata) error {
+		tracks := []meta.SubtitleTrack{}
+		for _, existing := range metadata.SubtitleTracks {
+			if existing.Source == "upload" && existing.Language == track.Language {
+				continue
+			}
+			// HLS allows one DEFAULT=YES per group
+			existing.Default = existing.Default && !track.Default
+			tracks = append(tracks, existing)
+		}
+		metadata.SubtitleTracks = append(tracks, track)
+
+		if metadata.Status != string(store.StatusReady) {
+			return nil
+		}
+		outputDir := fsutil.OutputsDir(root, videoID)
+		v, err := transcoder.segmentSubtitles(outputDir, *metadata, track, cues)
+		if err != nil {
+			return fmt.Errorf("segment subtitles: %w", err)
+		}
+
+		variants := []meta.Variant{}
+		for _, existing := range metadata.Variants {
+			if existing.Kind == "subtitles" {
+				if existing.PathOrPl == v.PathOrPl {
+					continue
+				}
+				existing.Default = existing.Default && !track.Default
+			}
+			variants = append(variants, existing)
+		}
+		metadata.Variants = append(variants, v)
+
+		if err := transcoder.WriteMasterPlaylist(*metadata); err != nil {
+			return fmt.Errorf("generate master playlist: %w", err)
+		}
+		return nil
+	})
+}
+
+// subtitleCues loads an uploaded track from its stored WebVTT, or extracts an
+// embedded one with ffmpeg.
+func (transcoder *Transcoder) subtitleCues(context context.Context, inputPath, outputDir string, metadata meta.Metadata, track meta.SubtitleTrack) ([]subtitle.Cue, error) {
+	sourcePath := fsutil.SubtitlePath(transcoder.root(metadata.Tenant), metadata.ID, track.Language)
+	if track.Source == "embedded" {
+		if err := os.MkdirAll(filepath.Join(outputDir, subtitleDir), 0755); err != nil {
+			return nil, err
+		}
+		sourcePath = filepath.Join(outputDir, subtitleDir, fmt.Sprintf("e%d.vtt.tmp", track.Index))
+		defer os.Remove(sourcePath)
+
+		args := []string{
+			"-y",
+			"-i", inputPath,
+			"-map", fmt.Sprintf("0:s:%d", track.Index),
+			"-c:s", "webvtt",
+			"-f", "webvtt",
+			sourcePath,
+		}
+		if _, err := transcoder.runner.Run(context, transcoder.config.FFmpegPath, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return subtitle.ParseVTT(data)
+}
+
+// segmentSubtitles writes the HLS WebVTT rendition of one track.
+func (transcoder *Transcoder) segmentSubtitles(outputDir string, metadata meta.Metadata, track meta.SubtitleTrack, cues []subtitle.Cue) (meta.Variant, error) {
+	v := meta.Variant{
+		Format:   "hls",
+		Kind:     "subtitles",
+		Codec:    "webvtt",
+		Group:    subtitleGroup,
+		Name:     subtitleName(track),
+		Language: track.Language,
+		Default:  track.Default,
+		Forced:   track.Forced,
+		PathOrPl: subtitleDir + "/" + subtitleRendition(track) + "/index.m3u8",
+	}
+
+	// cue times are relative to the start of the video; map them onto the
+	// timestamps of the video segments
+	var mpegts int64
+	for _, video := range metadata.Variants {
+		if video.Format == "hls" && video.Kind == "" {
+			if video.Container != "fmp4" {
+				mpegts = mpegtsStart
+			}
+			break
+		}
+	}
+
+	dir := filepath.Join(outputDir, filepath.FromSlash(path.Dir(v.PathOrPl)))
+	if err := os.RemoveAll(dir); err != nil {
+		return meta.Variant{}, err
+	}
+	size, err := subtitle.Segment(cues, dir, subtitle.SegmentOptions{
+		SegmentDuration: 4 * time.Second,
+		Duration:        time.Duration(metadata.DurationSec * float64(time.Second)),
+		MPEGTS:          mpegts,
+	})
+	if err != nil {
+		return meta.Variant{}, err
+	}
+	v.SizeBytes = size
+	v.ReadyAtUnix = time.Now().Unix()
+
+	return v, nil
+}
+
+// subtitleRendition is the directory under subtitles/: e<N> for embedded
+// streams, the language tag for uploads.
+func subtitleRendition(track meta.SubtitleTrack) string {
+	if track.Source == "embedded" {
+		return fmt.Sprintf("e%d", track.Index)
+	}
+	return track.Language
+}
+
+// subtitleName is the NAME shown in players' caption menus.
+func subtitleName(track meta.SubtitleTrack) string {
+	if track.Name != "" {
+		return track.Name
+	}
+	if track.Language != "" {
+		return strings.ToUpper(track.Language)
+	}
+	return fmt.Sprintf("Subtitles %d", track.Index+1)
+}
@@ -9,13 +9,17 @@ import (
 
 	"upload/internal/config"
 	"upload/internal/exec"
+	"upload/internal/fsutil"
+	"upload/internal/hls"
 	"upload/internal/meta"
+	"upload/internal/probe"
 )
 
 type Transcoder struct {
 	config config.Config
 	runner exec.Runner
 	store  meta.Store
+	prober *probe.Prober
 }
 
 func NewTranscoder(config config.Config, runner exec.Runner, store meta.Store) *Transcoder {
@@ -23,11 +27,26 @@ func NewTranscoder(config config.Config, runner exec.Runner, store meta.Store) *
 		config: config,
 		runner: runner,
 		store:  store,
+		prober: probe.NewProber(config, runner),
 	}
 }
 
+// CheckConfig reports settings the transcoder can't honor; callers refuse to
+// start on them rather than silently producing something else.
+func CheckConfig(config config.Config) error {
+	if _, err := LadderFor(config); err != nil {
+		return fmt.Errorf("invalid LADDER: %w", err)
+	}
+	// DASH and MP4 would be unencrypted copies of the encrypted renditions
+	if config.Encryption == "aes-128" && (config.DASHEnabled || config.MP4Download) {
+		return fmt.Errorf("HLS_ENCRYPTION=aes-128 cannot be combined with DASH_ENABLED or MP4_DOWNLOAD")
+	}
+	return nil
+}
+
 type Resolution struct {
 	Height       int
+	Encoder      string // ffmpeg video encoder, libx264 when empty
 	VideoBitrate string
 	AudioBitrate string
 	MaxRate      string
@@ -41,94 +60,326 @@ var resolutions = []Resolution{
 }
 
 func (transcoder *Transcoder) TranscodeVideo(context context.Context, videoID string) error {
-	metadata, err := transcoder.store.Get(videoID)
+	metadata, err := transcoder.store.Modify(videoID, func(metadata *meta.Metadata) error {
+		metadata.Status = string(store.StatusProcessing)
+		return nil
+	})
 	if err != nil {
-		return fmt.Errorf("get metadata: %w", err)
-	}
-
-	metadata.Status = string(store.StatusProcessing)
-	if err := transcoder.store.Update(metadata); err != nil {
 		return fmt.Errorf("update metadata: %w", err)
 	}
 
-	inputPath := filepath.Join(transcoder.config.StorageDir, "originals", videoID, "original"+filepath.Ext(metadata.OriginalFilename))
-	outputDir := filepath.Join(transcoder.config.StorageDir, "outputs", videoID)
+	root := transcoder.root(metadata.Tenant)
+	inputPath := fsutil.OriginalPath(root, videoID, metadata.OriginalFilename)
+	outputDir := fsutil.OutputsDir(root, videoID)
 
-	// 원본 해상도보다 낮은 해상도만 선택
-	targetResolutions := selectResolutions(metadata.Height)
+	// 원본 해상도보다 낮은 해상도만 선택 (세로 영상은 짧은 변 = 가로 기준)
+	ladder, err := transcoder.ladder(context)
+	if err != nil {
+		transcoder.fail(videoID, fmt.Sprintf("invalid LADDER: %v", err))
+		return fmt.Errorf("ladder: %w", err)
+	}
+	targetResolutions := selectResolutions(ladder.Resolutions, metadata.ShortSide())
+	portrait := metadata.Portrait()
+	// 오디오 분리 모드에서는 비디오 렌디션에 오디오를 넣지 않음
+	muxAudio := metadata.AudioCodec != "" && !transcoder.separateAudio()
 
 	for _, res := range targetResolutions {
-		resDir := filepath.Join(outputDir, fmt.Sprintf("%d", res.Height))
+		resDir := filepath.Join(outputDir, res.Dir())
 		if err := os.MkdirAll(resDir, 0755); err != nil {
+			transcoder.fail(videoID, fmt.Sprintf("create resolution dir failed: %v", err))
+
 			return fmt.Errorf("create resolution dir: %w", err)
 		}
+	}
 
-		playlistPath := filepath.Join(resDir, "index.m3u8")
-		segmentPath := filepath.Join(resDir, "%05d.ts")
-
-		args := []string{
-			"-y",
-			"-i", inputPath,
-			"-vf", fmt.Sprintf("scale=-2:%d", res.Height),
-			"-c:v", "libx264",
-			"-preset", "ultrafast", // 더 빠른 인코딩
-			"-profile:v", "main",
-			"-b:v", res.VideoBitrate,
-			"-maxrate", res.MaxRate,
-			"-bufsize", res.BufSize,
-			"-g", "48",
-			"-keyint_min", "48",
-			"-sc_threshold", "0",
-			"-c:a", "aac",
-			"-b:a", res.AudioBitrate,
-			"-threads", "2", // CPU 스레드 제한
-			"-f", "hls",
-			"-hls_time", "4",
-			"-hls_playlist_type", "vod",
-			"-hls_segment_filename", segmentPath,
-			playlistPath,
-		}
-
-		if _, err := transcoder.runner.Run(context, transcoder.config.FFmpegPath, args...); err != nil {
-			metadata.Status = string(store.StatusFailed)
-			metadata.ErrorMessage = fmt.Sprintf("transcode %dp failed: %v", res.Height, err)
-			transcoder.store.Update(metadata)
-
-			return fmt.Errorf("transcode %dp: %w", res.Height, err)
+	containers := make(map[string]string, len(targetResolutions))
+	switch ladder.Mode {
+	case ModeSinglePass:
+		// one muxer for every rendition, so one container for all of them
+		container := transcoder.container()
+		for _, res := range targetResolutions {
+			if res.profile().fmp4 {
+				container = "fmp4"
+			}
 		}
+		for _, res := range targetResolutions {
+			containers[res.Dir()] = container
+		}
+		if err := transcoder.encodeSinglePass(context, inputPath, outputDir, targetResolutions, container, portrait, muxAudio); err != nil {
+			transcoder.fail(videoID, fmt.Sprintf("single-pass transcode failed: %v", err))
+
+			return fmt.Errorf("single-pass transcode: %w", err)
+		}
+	default:
+		for _, res := range targetResolutions {
+			container := transcoder.container()
+			if res.profile().fmp4 {
+				container = "fmp4"
+			}
+			containers[res.Dir()] = container
+			if err := transcoder.encodeRendition(context, inputPath, outputDir, res, container, portrait, muxAudio); err != nil {
+				transcoder.fail(videoID, fmt.Sprintf("transcode %s failed: %v", res.Label(), err))
+
+				return fmt.Errorf("transcode %s: %w", res.Label(), err)
+			}
+		}
+	}
 
+	// 렌디션마다 Variant 기록 (인코딩 방식과 무관하게 동일)
+	for _, res := range targetResolutions {
 		varient := meta.Variant{
 			Format:      "hls",
+			Codec:       res.Codec(),
 			Height:      res.Height,
 			BitrateKbps: parseBitrate(res.VideoBitrate),
-			PathOrPl:    fmt.Sprintf("%d/index.m3u8", res.Height),
+			PathOrPl:    res.Dir() + "/index.m3u8",
+			Container:   containers[res.Dir()],
+		}
+		if err := transcoder.measureVariant(context, outputDir, &varient); err != nil {
+			transcoder.fail(videoID, fmt.Sprintf("measure %s failed: %v", res.Label(), err))
+
+			return fmt.Errorf("measure %s: %w", res.Label(), err)
 		}
 		metadata.Variants = append(metadata.Variants, varient)
 	}
 
-	if err := transcoder.generateMasterPlaylist(outputDir, targetResolutions); err != nil {
+	if metadata.AudioCodec != "" && transcoder.separateAudio() {
+		// fMP4 video can't reference TS audio reliably; match the video
+		audioContainer := transcoder.container()
+		for _, container := range containers {
+			if container == "fmp4" {
+				audioContainer = "fmp4"
+			}
+		}
+		audioVariants, err := transcoder.encodeAudioRenditions(context, inputPath, outputDir, metadata, audioContainer)
+		if err != nil {
+			transcoder.fail(videoID, fmt.Sprintf("audio renditions failed: %v", err))
+
+			return fmt.Errorf("audio renditions: %w", err)
+		}
+		metadata.Variants = append(metadata.Variants, audioVariants...)
+	}
+
+	// 자막: 인코딩 중에 업로드된 트랙도 포함되도록 최신 메타데이터에서 읽음
+	if latest, err := transcoder.store.Get(videoID); err == nil {
+		metadata.SubtitleTracks = latest.SubtitleTracks
+	}
+	metadata.Variants = append(metadata.Variants, transcoder.packageSubtitles(context, inputPath, outputDir, metadata)...)
+
+	metadata.Encryption = ""
+	if transcoder.encrypted() {
+		if err := transcoder.encryptRenditions(root, outputDir, videoID, metadata.Variants); err != nil {
+			transcoder.fail(videoID, fmt.Sprintf("encrypt segments failed: %v", err))
+
+			return fmt.Errorf("encrypt segments: %w", err)
+		}
+		metadata.Encryption = transcoder.config.Encryption
+	}
+
+	if err := transcoder.WriteMasterPlaylist(metadata); err != nil {
+		transcoder.fail(videoID, fmt.Sprintf("generate master playlist failed: %v", err))
+
 		return fmt.Errorf("generate master playlist: %w", err)
 	}
 
-	metadata.Status = string(store.StatusReady)
-	if err := transcoder.store.Update(metadata); err != nil {
+	// DASH and MP4 would be unencrypted copies under /streams
+	if transcoder.config.DASHEnabled && !transcoder.encrypted() {
+		var dashVariants []meta.Variant
+		if transcoder.container() == "fmp4" {
+			// CMAF: the MPD points at the HLS segments, nothing is copied
+			dashVariants, err = transcoder.writeSharedMPD(outputDir, metadata)
+		} else {
+			// WebVTT segments have no DASH equivalent; captions are HLS-only
+			var hlsVariants []meta.Variant
+			for _, v := range metadata.Variants {
+				if v.Format == "hls" && v.Kind != "subtitles" {
+					hlsVariants = append(hlsVariants, v)
+				}
+			}
+			dashVariants, err = transcoder.packageDASH(context, outputDir, hlsVariants, muxAudio)
+		}
+		if err != nil {
+			transcoder.fail(videoID, fmt.Sprintf("package dash failed: %v", err))
+
+			return fmt.Errorf("package dash: %w", err)
+		}
+		metadata.Variants = append(metadata.Variants, dashVariants...)
+	}
+
+	if transcoder.config.MP4Download && !transcoder.encrypted() {
+		downloads, err := transcoder.packageMP4(context, outputDir, metadata.Variants)
+		if err != nil {
+			transcoder.fail(videoID, fmt.Sprintf("package mp4 failed: %v", err))
+
+			return fmt.Errorf("package mp4: %w", err)
+		}
+		metadata.Variants = append(metadata.Variants, downloads...)
+	}
+
+	// 자막 트랙·상세 정보는 그 사이 바뀌었을 수 있으므로 렌디션 결과만 기록
+	_, err = transcoder.store.Modify(videoID, func(latest *meta.Metadata) error {
+		latest.Variants = metadata.Variants
+		latest.Encryption = metadata.Encryption
+		latest.Status = string(store.StatusReady)
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("update final status: %w", err)
 	}
 
 	return nil
 }
 
-func (transcoder *Transcoder) generateMasterPlaylist(outputDir string, targetResolutions []Resolution) error {
-	masterContent := "#EXTM3U\n#EXT-X-VERSION:3\n\n"
+// fail records message as the reason the video could not be processed.
+func (transcoder *Transcoder) fail(videoID, message string) {
+	transcoder.store.Modify(videoID, func(metadata *meta.Metadata) error {
+		metadata.Status = string(store.StatusFailed)
+		metadata.ErrorMessage = message
+		return nil
+	})
+}
 
-	for _, res := range targetResolutions {
-		bandwidth := parseBitrate(res.VideoBitrate)*1000 + parseBitrate(res.AudioBitrate)*1000
-		masterContent += fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d,RESOLUTION=%dx%d\n", bandwidth, res.Height*16/9, res.Height)
-		masterContent += fmt.Sprintf("%d/index.m3u8\n", res.Height)
+// encodeRendition runs one ffmpeg for a single rendition.
+func (transcoder *Transcoder) encodeRendition(context context.Context, inputPath, outputDir string, res Resolution, container string, portrait, muxAudio bool) error {
+	resDir := filepath.Join(outputDir, res.Dir())
+	playlistPath := filepath.Join(resDir, "index.m3u8")
+
+	args := []string{
+		"-y",
+		"-i", inputPath,
+		"-vf", scaleFilter(res.Height, portrait),
+	}
+	args = append(args, videoArgs(res, ":v")...)
+	if muxAudio {
+		args = append(args, "-c:a", "aac", "-b:a", res.AudioBitrate)
+	} else {
+		args = append(args, "-an")
 	}
+	args = append(args,
+		"-threads", "2", // CPU 스레드 제한
+		"-f", "hls",
+		"-hls_time", "4",
+		"-hls_playlist_type", "vod",
+	)
+	args = append(args, segmentArgs(resDir, container)...)
+	args = append(args, playlistPath)
+
+	_, err := transcoder.runner.Run(context, transcoder.config.FFmpegPath, args...)
+	return err
+}
+
+// WriteMasterPlaylist (re)writes master.m3u8 from the variants recorded in
+// the metadata.
+func (transcoder *Transcoder) WriteMasterPlaylist(metadata meta.Metadata) error {
+	master := hls.MasterPlaylist{Version: 3}
+
+	// separate audio renditions: EXT-X-MEDIA entries, and their bitrate is
+	// added to every video variant that references the group
+	var audioPeak, audioAverage int
+	var audioCodecs string
+	for _, v := range metadata.Variants {
+		if v.Format != "hls" || v.Kind != "audio" {
+			continue
+		}
+		if v.Container == "fmp4" {
+			master.Version = 7
+		}
+		if v.Group == lowAudioGroup {
+			master.Variants = append(master.Variants, hls.VariantStream{
+				URI:              v.PathOrPl,
+				Bandwidth:        bandwidthOf(v),
+				AverageBandwidth: v.BandwidthAverage,
+				Codecs:           v.Codecs,
+				AudioOnly:        true,
+			})
+			continue
+		}
+		master.Media = append(master.Media, hls.Media{
+			Type:       "AUDIO",
+			GroupID:    v.Group,
+			Name:       v.Name,
+			Language:   v.Language,
+			Default:    v.Default,
+			AutoSelect: true,
+			Channels:   fmt.Sprintf("%d", v.Channels),
+			URI:        v.PathOrPl,
+		})
+		audioPeak = max(audioPeak, bandwidthOf(v))
+		audioAverage = max(audioAverage, v.BandwidthAverage)
+		audioCodecs = v.Codecs
+	}
+
+	// WebVTT subtitle renditions; only one may be DEFAULT=YES
+	hasDefault := false
+	for _, v := range metadata.Variants {
+		if v.Format != "hls" || v.Kind != "subtitles" {
+			continue
+		}
+		master.Media = append(master.Media, hls.Media{
+			Type:       "SUBTITLES",
+			GroupID:    v.Group,
+			Name:       v.Name,
+			Language:   v.Language,
+			Default:    v.Default && !hasDefault,
+			AutoSelect: true,
+			Forced:     v.Forced,
+			URI:        v.PathOrPl,
+		})
+		hasDefault = hasDefault || v.Default
+	}
+
+	for _, v := range metadata.Variants {
+		if v.Format != "hls" || v.Kind != "" {
+			continue
+		}
+		if v.Container == "fmp4" {
+			// fMP4 media playlists use EXT-X-MAP, which needs version 6+;
+			// ffmpeg writes them as version 7
+			master.Version = 7
+		}
+		stream := hls.VariantStream{
+			URI:              v.PathOrPl,
+			Bandwidth:        bandwidthOf(v),
+			AverageBandwidth: v.BandwidthAverage,
+			Width:            v.Width,
+			Height:           v.EncodedHeight,
+			Codecs:           v.Codecs,
+			FrameRate:        v.FrameRate,
+		}
+		for _, media := range master.Media {
+			if media.Type == "SUBTITLES" {
+				stream.Subtitles = subtitleGroup
+			}
+		}
+		if audioCodecs != "" {
+			stream.Audio = audioGroup
+			stream.Bandwidth += audioPeak
+			if stream.AverageBandwidth > 0 {
+				stream.AverageBandwidth += audioAverage
+			}
+			stream.Codecs = hls.JoinCodecs(stream.Codecs, audioCodecs)
+		}
+		master.Variants = append(master.Variants, stream)
+	}
+
+	outputDir := fsutil.OutputsDir(transcoder.root(metadata.Tenant), metadata.ID)
 	masterPath := filepath.Join(outputDir, "master.m3u8")
 
-	return os.WriteFile(masterPath, []byte(masterContent), 0644)
+	return os.WriteFile(masterPath, []byte(master.String()), 0644)
+}
+
+// root is the storage root of the tenant's videos.
+func (transcoder *Transcoder) root(tenant string) string {
+	return fsutil.TenantRoot(transcoder.config.StorageDir, tenant)
+}
+
+// bandwidthOf is the measured peak, or the nominal bitrate for variants
+// recorded before measurements existed.
+func bandwidthOf(v meta.Variant) int {
+	if v.BandwidthPeak > 0 {
+		return v.BandwidthPeak
+	}
+	return v.BitrateKbps * 1000
 }
 
 func parseBitrate(inputString string) int {
@@ -138,20 +389,58 @@ func parseBitrate(inputString string) int {
 	return value
 }
 
+// container is the HLS segment container configured via HLS_SEGMENT_TYPE.
+func (transcoder *Transcoder) container() string {
+	if transcoder.config.SegmentType == "fmp4" {
+		return "fmp4"
+	}
+	return "ts"
+}
+
+// segmentArgs names the segments written into dir (which may contain %v).
+func segmentArgs(dir, container string) []string {
+	if container == "fmp4" {
+		return []string{
+			"-hls_segment_type", "fmp4",
+			"-hls_fmp4_init_filename", "init.mp4",
+			"-hls_segment_filename", filepath.Join(dir, "%05d.m4s"),
+		}
+	}
+	return []string{"-hls_segment_filename", filepath.Join(dir, "%05d.ts")}
+}
+
+// scaleFilter scales the short side to the ladder height. ffmpeg applies the
+// display rotation before the filter graph, so for portrait sources the short
+// side is the width.
+func scaleFilter(height int, portrait bool) string {
+	if portrait {
+		return fmt.Sprintf("scale=%d:-2", height)
+	}
+	return fmt.Sprintf("scale=-2:%d", height)
+}
+
 // selectResolutions 원본 해상도보다 낮은 해상도들만 선택
-func selectResolutions(originalHeight int) []Resolution {
+func selectResolutions(ladder []Resolution, shortSide int) []Resolution {
 	var selected []Resolution
 
-	for _, res := range resolutions {
-		if res.Height < originalHeight {
+	for _, res := range ladder {
+		if res.Height < shortSide {
 			selected = append(selected, res)
 		}
 	}
 
 	// 원본이 너무 작으면 (360p 이하) 원본 그대로 사용
 	if len(selected) == 0 {
-		// 가장 낮은 해상도 하나만 사용
-		selected = append(selected, resolutions[0]) // 480p
+		// 가장 낮은 해상도만 사용 (코덱별로 하나씩)
+		lowest := ladder[0].Height
+		for _, res := range ladder {
+			lowest = min(lowest, res.Height)
+		}
+		for _, res := range ladder {
+			if res.Height == lowest {
+				selected = append(selected, res)
+			}
+		}
 	}
 
 	return selected
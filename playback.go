@@ -0,0 +1,440 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"upload/internal/auth"
+	"upload/internal/config"
+	"upload/internal/dash"
+	"upload/internal/frame"
+	"upload/internal/fsutil"
+	"upload/internal/hls"
+	"upload/internal/httpapi"
+	"upload/internal/id"
+	"upload/internal/meta"
+	appmiddleware "upload/internal/middleware"
+	"upload/internal/signing"
+	"upload/internal/subtitle"
+	"upload/internal/thumbnail"
+)
+
+func (server *Server) playbackURLs(c echo.Context) error {
+	vid := c.Param("id")
+	m, err := tenantStore(c, server.store).Get(vid)
+	if err != nil || !visible(c, m) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if m.Status != "ready" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "video is " + m.Status})
+	}
+
+	grant := signing.Grant{Expires: time.Now().Add(server.config.SigningTTL)}
+	if c.QueryParam("bind_ip") == "true" {
+		grant.IP = c.RealIP()
+	}
+	sign := func(p string) string {
+		if server.signer == nil {
+			return p
+		}
+		return server.signer.Sign(p, grant)
+	}
+
+	resp := httpapi.PlaybackResponse{
+		HLS:       sign("/videos/" + vid + "/master.m3u8"),
+		Downloads: map[string]string{},
+	}
+	for _, v := range m.Variants {
+		switch v.Format {
+		case "dash":
+			resp.DASH = sign("/videos/" + vid + "/manifest.mpd")
+		case "mp4":
+			resp.Downloads[strconv.Itoa(v.Height)] = sign(fmt.Sprintf("/videos/%s/download/%d", vid, v.Height))
+		}
+	}
+	if m.Sprites != nil {
+		resp.Sprites = sign("/" + m.Sprites.VTT)
+	}
+	resp.Frames = sign("/videos/" + vid + "/frame")
+	if server.signer != nil {
+		resp.ExpiresAt = grant.Expires
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (server *Server) masterPlaylist(c echo.Context) error {
+	vid := c.Param("id")
+	m, err := tenantStore(c, server.store).Get(vid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	p := filepath.Join(fsutil.OutputsDir(fsutil.TenantRoot(server.config.StorageDir, m.Tenant), vid), "master.m3u8")
+	if _, err := os.Stat(p); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if server.signer != nil {
+		return servePlaylist(c, server.signer, p, "/streams/"+vid+"/master.m3u8")
+	}
+
+	return c.File(p)
+}
+
+func (server *Server) dashManifest(c echo.Context) error {
+	vid := c.Param("id")
+	if !id.Valid(vid) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	m, err := tenantStore(c, server.store).Get(vid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	p := filepath.Join(fsutil.OutputsDir(fsutil.TenantRoot(server.config.StorageDir, m.Tenant), vid), "dash", "manifest.mpd")
+	if _, err := os.Stat(p); err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+
+	target := "/streams/" + vid + "/dash/manifest.mpd"
+	if server.signer != nil {
+		// the MPD is served with its segment URLs signed
+		target = server.signer.Sign(target, c.Get(appmiddleware.GrantKey).(signing.Grant))
+	}
+
+	return c.Redirect(http.StatusFound, target)
+}
+
+func (server *Server) download(c echo.Context) error {
+	vid := c.Param("id")
+	m, err := tenantStore(c, server.store).Get(vid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(c.Param("height"), "p"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid height"})
+	}
+
+	var download *meta.Variant
+	for i, v := range m.Variants {
+		if v.Format == "mp4" && v.Height == height {
+			download = &m.Variants[i]
+			break
+		}
+	}
+	if download == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "no mp4 download for this height"})
+	}
+
+	f, err := os.Open(filepath.Join(fsutil.OutputsDir(fsutil.TenantRoot(server.config.StorageDir, m.Tenant), vid), filepath.FromSlash(download.PathOrPl)))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot read file"})
+	}
+
+	base := strings.TrimSuffix(m.OriginalFilename, filepath.Ext(m.OriginalFilename))
+	if base == "" {
+		base = vid
+	}
+	filename := fmt.Sprintf("%s_%dp.mp4", base, height)
+	header := c.Response().Header()
+	header.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	header.Set("ETag", fmt.Sprintf(`"%x-%x"`, info.Size(), info.ModTime().UnixNano()))
+	http.ServeContent(c.Response(), c.Request(), filename, info.ModTime(), f)
+
+	return nil
+}
+
+func (server *Server) extractFrame(c echo.Context) error {
+	vid := c.Param("id")
+	m, err := tenantStore(c, server.store).Get(vid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+
+	t, err := strconv.ParseFloat(c.QueryParam("t"), 64)
+	if err != nil || !(t >= 0) || math.IsInf(t, 0) || (m.DurationSec > 0 && t >= m.DurationSec) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "t must be a position in the video, in seconds"})
+	}
+	// millisecond precision is plenty and keeps cache names short
+	ms := int64(math.Round(t * 1000))
+	width := defaultFrameWidth
+	if w := c.QueryParam("w"); w != "" {
+		width, err = strconv.Atoi(w)
+		if err != nil || width < minFrameWidth || width > maxFrameWidth {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("w must be between %d and %d", minFrameWidth, maxFrameWidth)})
+		}
+	}
+	format := strings.ToLower(c.QueryParam("format"))
+	if format == "" || format == "jpg" {
+		format = "jpeg"
+	}
+	ext, ok := thumbnail.FrameFormats[format]
+	if !ok {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "format must be jpeg, webp or png"})
+	}
+
+	source, err := frame.Source(fsutil.TenantRoot(server.config.StorageDir, m.Tenant), m, width)
+	if err != nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": err.Error()})
+	}
+	name := fmt.Sprintf("%s_%d_%d%s", vid, ms, width, ext)
+	p, err := server.frames.Get(c.Request().Context(), name, func(context context.Context, output string) error {
+		return server.thumbnails.ExtractFrame(context, source, output, float64(ms)/1000, width, format)
+	})
+	if errors.Is(err, frame.ErrBusy) {
+		c.Response().Header().Set("Retry-After", "1")
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": err.Error()})
+	}
+	if errors.Is(err, context.Canceled) {
+		return nil // the client is gone
+	}
+	if err != nil {
+		log.Printf("Failed to extract frame %s: %v", name, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot extract frame"})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, max-age=86400")
+	return c.File(p)
+}
+
+func (server *Server) thumbnailURLs(c echo.Context) error {
+	m, err := tenantStore(c, server.store).Get(c.Param("id"))
+	if err != nil || !visible(c, m) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+
+	grant := signing.Grant{Expires: time.Now().Add(server.config.SigningTTL)}
+	image := func(thumb meta.Thumbnail) httpapi.ThumbnailImage {
+		url := "/" + thumb.Path
+		if server.signer != nil {
+			url = server.signer.Sign(url, grant)
+		}
+		return httpapi.ThumbnailImage{URL: url, TimestampSec: thumb.Timestamp, Width: thumb.Width, Height: thumb.Height}
+	}
+
+	resp := httpapi.ThumbnailsResponse{Images: []httpapi.ThumbnailImage{}}
+	if m.Thumbnails != nil {
+		for _, thumb := range m.Thumbnails.Images {
+			resp.Images = append(resp.Images, image(thumb))
+		}
+		if m.Thumbnails.Poster != nil {
+			poster := image(*m.Thumbnails.Poster)
+			resp.Poster = &poster
+		}
+	}
+	if server.signer != nil {
+		resp.ExpiresAt = grant.Expires
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (server *Server) key(c echo.Context) error {
+	if !keyAuthorized(c, server.config, server.signer, server.authenticator, server.store) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "forbidden"})
+	}
+	vid := c.Param("id")
+	n, err := strconv.Atoi(c.Param("n"))
+	if !id.Valid(vid) || err != nil || n < 0 {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	m, err := server.store.Get(vid)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	key, err := os.ReadFile(fsutil.KeyPath(fsutil.TenantRoot(server.config.StorageDir, m.Tenant), vid, n))
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	return c.Blob(http.StatusOK, "application/octet-stream", key)
+}
+
+func (server *Server) thumbnailFile(c echo.Context) error {
+	rel := path.Clean("/" + c.Param("*"))
+	p, ok := videoFile(c, server.config, server.store, "thumbnails")
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if path.Ext(rel) == ".vtt" {
+		if server.signer != nil {
+			return serveSignedVTT(c, server.signer, p, "/thumbnails"+rel)
+		}
+		c.Response().Header().Set(echo.HeaderContentType, "text/vtt; charset=utf-8")
+	}
+
+	return c.File(p)
+}
+
+func (server *Server) streamFile(c echo.Context) error {
+	rel := path.Clean("/" + c.Param("*"))
+	p, ok := videoFile(c, server.config, server.store, "outputs")
+	if !ok {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if server.signer != nil && path.Ext(rel) == ".m3u8" {
+		return servePlaylist(c, server.signer, p, "/streams"+rel)
+	}
+	if server.signer != nil && path.Ext(rel) == ".mpd" {
+		return serveSignedMPD(c, server.signer, p, "/streams"+rel)
+	}
+
+	return c.File(p)
+}
+
+// keyAuthorized checks the URL signature that signed playlists put on key
+// URIs, API credentials with read access to the video, or, only while the API
+// is open, the key token, sent as a bearer token or as ?token= for players
+// that can't set headers. The token is global, so with credentials (and
+// tenants) configured it would release every tenant's keys.
+func keyAuthorized(c echo.Context, cfg config.Config, signer *signing.Signer, authenticator *auth.Authenticator, store meta.Store) bool {
+	if signer != nil {
+		if _, err := signer.Verify(c.Request().URL.Path, c.QueryParams(), c.RealIP()); err == nil {
+			return true
+		}
+	}
+	if authenticator != nil {
+		principal, err := authenticator.Authenticate(c.Request())
+		if err != nil || !principal.Has(auth.ScopeRead) {
+			return false
+		}
+		m, err := store.Get(c.Param("id"))
+		return err == nil && principal.InTenant(m.Tenant) && (principal.Owns(m.Owner) || m.Shared())
+	}
+	if cfg.KeyToken == "" {
+		return false
+	}
+	token := c.QueryParam("token")
+	if auth := c.Request().Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(cfg.KeyToken)) == 1
+}
+
+// servePlaylist serves the playlist at file, signing every URI in it with
+// the grant of the current request. Relative URIs are resolved against
+// publicPath, the URL the playlist is published under.
+func servePlaylist(c echo.Context, signer *signing.Signer, file, publicPath string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	return serveSignedPlaylist(c, signer, data, publicPath)
+}
+
+// serveSignedPlaylist is servePlaylist for a playlist generated in memory.
+func serveSignedPlaylist(c echo.Context, signer *signing.Signer, data []byte, publicPath string) error {
+	grant, _ := c.Get(appmiddleware.GrantKey).(signing.Grant)
+	grant.Prefix = ""
+
+	base := path.Dir(publicPath)
+	data = hls.RewriteURIs(data, func(uri string) string {
+		if strings.Contains(uri, "://") {
+			return uri
+		}
+		if !strings.HasPrefix(uri, "/") {
+			uri = path.Join(base, uri)
+		}
+		return signer.Sign(uri, grant)
+	})
+
+	// every response carries fresh signatures; don't let caches share them
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	return c.Blob(http.StatusOK, "application/vnd.apple.mpegurl", data)
+}
+
+// serveSignedMPD serves a DASH manifest whose segment URLs carry a
+// signature for everything under /streams/<id>/: ffmpeg's MPDs address
+// segments through templates, so they can't be signed one by one, and the
+// shared MPD points into the HLS renditions next to dash/.
+func serveSignedMPD(c echo.Context, signer *signing.Signer, file, publicPath string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	grant, _ := c.Get(appmiddleware.GrantKey).(signing.Grant)
+	vid, _, _ := strings.Cut(strings.TrimPrefix(publicPath, "/streams/"), "/")
+	grant.Prefix = "/streams/" + vid + "/"
+	query := signer.Query(grant.Prefix, grant)
+
+	data = dash.RewriteURLs(data, func(url string) string {
+		if strings.Contains(url, "://") {
+			return url
+		}
+		if strings.Contains(url, "?") {
+			return url + "&" + query
+		}
+		return url + "?" + query
+	})
+
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	return c.Blob(http.StatusOK, "application/dash+xml", data)
+}
+
+// serveSignedVTT serves a WebVTT thumbnail track, signing the image URL of
+// every cue; the #xywh fragment stays after the signature.
+func serveSignedVTT(c echo.Context, signer *signing.Signer, file, publicPath string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	cues, err := subtitle.ParseVTT(data)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "invalid thumbnail track"})
+	}
+	grant, _ := c.Get(appmiddleware.GrantKey).(signing.Grant)
+	grant.Prefix = ""
+
+	base := path.Dir(publicPath)
+	for i, cue := range cues {
+		uri, fragment, _ := strings.Cut(cue.Text, "#")
+		if !strings.Contains(uri, "://") {
+			if !strings.HasPrefix(uri, "/") {
+				uri = path.Join(base, uri)
+			}
+			uri = signer.Sign(uri, grant)
+		}
+		if fragment != "" {
+			uri += "#" + fragment
+		}
+		cues[i].Text = uri
+	}
+
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	return c.Blob(http.StatusOK, "text/vtt; charset=utf-8", subtitle.FormatVTT(cues))
+}
+
+// videoFile resolves a /streams or /thumbnails path, whose first segment is
+// the video id, inside area of the video's tenant root.
+func videoFile(c echo.Context, cfg config.Config, store meta.Store, area string) (string, bool) {
+	rel := path.Clean("/" + c.Param("*"))
+	vid, _, _ := strings.Cut(strings.TrimPrefix(rel, "/"), "/")
+	if !id.Valid(vid) {
+		return "", false
+	}
+	m, err := tenantStore(c, store).Get(vid)
+	if err != nil {
+		return "", false
+	}
+
+	return filepath.Join(fsutil.TenantRoot(cfg.StorageDir, m.Tenant), area, filepath.FromSlash(rel)), true
+}
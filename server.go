@@ -1,160 +1,411 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
 	"io"
+	"log"
+	"mime"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 
+	"upload/internal/auth"
+	"upload/internal/collection"
 	"upload/internal/config"
+	"upload/internal/exec"
+	"upload/internal/frame"
+	"upload/internal/fsck"
 	"upload/internal/fsutil"
-	"upload/internal/id"
+	"upload/internal/importer"
 	"upload/internal/meta"
+	appmiddleware "upload/internal/middleware"
 	"upload/internal/processor"
+	"upload/internal/quota"
+	"upload/internal/search"
+	"upload/internal/signing"
+	"upload/internal/thumbnail"
+	"upload/internal/transcoder"
+)
+
+const (
+	maxSubtitleBytes = 10 * 1024 * 1024
+	maxDetailsBytes  = 64 * 1024
+
+	// On-demand frame widths
+	defaultFrameWidth = 480
+	minFrameWidth     = 16
+	maxFrameWidth     = 3840
 )
 
 type uploadResponse struct {
 	ID string `json:"id"`
 }
 
+// Server holds what the handlers share.
+type Server struct {
+	config        config.Config
+	store         *search.IndexedStore
+	index         *search.Index
+	collections   *collection.Store
+	quotas        *quota.Manager
+	importer      *importer.Importer
+	frames        *frame.Cache
+	thumbnails    *thumbnail.Generator
+	signer        *signing.Signer      // nil without URL_SIGNING_SECRET
+	authenticator *auth.Authenticator  // nil while the API is open
+	enqueue       func(videoID string) // queues a video once its encoding quota allows
+}
+
 func main() {
 	cfg := config.Load()
 
+	// Not in Go's built-in table; players are picky about these
+	mime.AddExtensionType(".m3u8", "application/vnd.apple.mpegurl")
+	mime.AddExtensionType(".mpd", "application/dash+xml")
+	mime.AddExtensionType(".m4s", "video/iso.segment")
+
 	// Ensure base storage dirs exist
-	os.MkdirAll(fsutil.MetadataDir(cfg.StorageDir), 0o755)
-	os.MkdirAll(filepath.Join(cfg.StorageDir, "originals"), 0o755)
-	os.MkdirAll(filepath.Join(cfg.StorageDir, "outputs"), 0o755)
-	os.MkdirAll(filepath.Join(cfg.StorageDir, "thumbnails"), 0o755)
+	if err := fsutil.EnsureLayout(cfg.StorageDir); err != nil {
+		log.Fatalf("create storage layout: %v", err)
+	}
+
+	if err := transcoder.CheckConfig(cfg); err != nil {
+		log.Fatalf("%v", err)
+	}
+	logLadder(cfg)
 
-	store := meta.NewJSONStore(fsutil.MetadataDir(cfg.StorageDir))
+	// Every metadata write also updates the in-memory search index; writes
+	// made by videoctl are picked up by store.Refresh before each search
+	index := search.NewIndex(cfg.StorageDir)
+	store := search.NewIndexedStore(meta.NewJSONStore(fsutil.MetadataDir(cfg.StorageDir)), index)
 	proc := processor.New(cfg, store)
+	queue := processor.NewQueue(proc, cfg.Workers)
+	quotas, err := quota.NewManager(cfg, store)
+	if err != nil {
+		log.Fatalf("load tenants: %v", err)
+	}
+	// 큐에 넣기 전에 테넌트의 월 인코딩 할당량 확인
+	enqueue := func(vid string) {
+		if m, err := store.Get(vid); err == nil {
+			if err := quotas.CheckEncoding(m.Tenant); err != nil {
+				log.Printf("Not processing %s: %v", vid, err)
+				store.Modify(vid, func(m *meta.Metadata) error {
+					m.Status = "failed"
+					m.ErrorMessage = err.Error()
+					return nil
+				})
+				return
+			}
+		}
+		queue.Enqueue(vid)
+	}
+	frames, err := frame.NewCache(fsutil.FrameCacheDir(cfg.StorageDir), int64(cfg.FrameCacheMB)*1024*1024, cfg.FrameWorkers)
+	if err != nil {
+		log.Fatalf("open frame cache: %v", err)
+	}
+
+	// Periodic storage consistency check
+	if cfg.GCInterval > 0 {
+		var repair *fsck.RepairOptions
+		if cfg.GCRepair {
+			repair = &fsck.RepairOptions{}
+		}
+		checker := fsck.NewChecker(cfg.StorageDir, store)
+		go checker.Schedule(context.Background(), cfg.GCInterval, fsck.Options{MinAge: cfg.GCMinAge}, repair, enqueue)
+	}
+
+	// Pick up videos queued before a restart or registered by `videoctl ingest`
+	store.Refresh()
+	if videos, err := store.List(); err == nil {
+		for _, v := range videos {
+			if v.Status == "queued" {
+				enqueue(v.ID)
+			}
+		}
+	}
+
+	// Signed URLs (URL_SIGNING_SECRET): without a secret everything stays public
+	var signer *signing.Signer
+	if cfg.SigningSecret != "" {
+		signer = signing.NewSigner(cfg.SigningSecret)
+	}
+
+	// API authentication (API_KEYS_FILE, JWT_SECRET, JWT_JWKS_FILE)
+	authenticator, err := auth.NewAuthenticator(cfg)
+	if err != nil {
+		log.Fatalf("load credentials: %v", err)
+	}
+	if authenticator == nil {
+		log.Printf("No API credentials configured; the API is open to everyone")
+	} else if cfg.KeyToken != "" {
+		log.Printf("HLS_KEY_TOKEN is ignored while API credentials are configured; keys are released to callers who can read the video or hold a signed key URL")
+	}
+	logEncryption(cfg, signer, authenticator)
+
+	server := &Server{
+		config:        cfg,
+		store:         store,
+		index:         index,
+		collections:   collection.NewStore(fsutil.CollectionsDir(cfg.StorageDir)),
+		quotas:        quotas,
+		importer:      importer.NewImporter(cfg, store, enqueue),
+		frames:        frames,
+		thumbnails:    thumbnail.NewGenerator(cfg, exec.NewCommandRunner()),
+		signer:        signer,
+		authenticator: authenticator,
+		enqueue:       enqueue,
+	}
 
 	e := echo.New()
 	e.HideBanner = true
 	e.Use(middleware.Recover())
 	e.Use(middleware.Logger())
 	e.Use(middleware.CORS())
+	if cfg.TrustProxy {
+		e.IPExtractor = echo.ExtractIPFromXFFHeader()
+	} else {
+		e.IPExtractor = echo.ExtractIPDirect()
+	}
+	server.routes(e)
+
+	e.Logger.Fatal(e.Start(":" + cfg.Port))
+}
+
+func (server *Server) routes(e *echo.Echo) {
+	authn := appmiddleware.NewAuth(server.authenticator)
+	var requireSigned []echo.MiddlewareFunc
+	if server.signer != nil {
+		requireSigned = append(requireSigned, appmiddleware.NewSignedURLs(server.signer).Require())
+	}
+
+	// Routes players hit: with URL signing the signature is the
+	// authorization, otherwise the read scope on a video the caller owns.
+	playback := requireSigned
+	if server.signer == nil {
+		playback = []echo.MiddlewareFunc{authn.Require(auth.ScopeRead), visibleOnly(server.store)}
+	}
 
 	// Health
-	e.GET("/health", func(c echo.Context) error {
-		return c.String(http.StatusOK, "OK")
-	})
+	e.GET("/health", server.health)
+
+	// Storage and encoding usage of the caller's tenant. Admins outside any
+	// tenant pick one with ?tenant= (default: the default tenant).
+	e.GET("/usage", server.usage, authn.Require(auth.ScopeRead))
+
+	// Usage of every tenant
+	e.GET("/tenants", server.tenants, authn.Require(auth.ScopeAdmin))
 
 	// Get video list
-	e.GET("/videos", func(c echo.Context) error {
-		videos, err := store.List()
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list videos"})
-		}
-		return c.JSON(http.StatusOK, map[string]interface{}{"videos": videos})
-	})
-
-	// Upload: accept a multipart file and create metadata
-	e.POST("/videos", func(c echo.Context) error {
-		fh, err := c.FormFile("file")
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "file is required"})
-		}
-		src, err := fh.Open()
-		if err != nil {
-			return c.JSON(http.StatusBadRequest, map[string]string{"error": "cannot open file"})
-		}
-		defer src.Close()
+	// ?q=, ?tag= (repeatable), ?status=, ?visibility= and ?custom.<key>=
+	// narrow the list down.
+	e.GET("/videos", server.listVideos, authn.Require(auth.ScopeRead))
 
-		vid := id.New()
-		origDir := filepath.Join(cfg.StorageDir, "originals", vid)
-		if err := os.MkdirAll(origDir, 0o755); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot create dir"})
-		}
-		ext := filepath.Ext(fh.Filename)
-		if ext == "" {
-			ext = ".mp4"
-		}
-		dstPath := filepath.Join(origDir, "original"+ext)
-		dst, err := os.Create(dstPath)
-		if err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot save file"})
-		}
-		n, cErr := io.Copy(dst, src)
-		dErr := dst.Close()
-		if cErr != nil || dErr != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot write file"})
-		}
+	// Upload: stream the multipart "file" part straight into originals/
+	e.POST("/videos", server.uploadVideo, authn.Require(auth.ScopeUpload))
 
-		// Get MIME type from header first
-		mimeType := fh.Header.Get("Content-Type")
-
-		// If header doesn't have MIME type, detect from extension
-		if mimeType == "" || mimeType == "application/octet-stream" {
-			switch strings.ToLower(ext) {
-			case ".mp4":
-				mimeType = "video/mp4"
-			case ".mov":
-				mimeType = "video/quicktime"
-			case ".avi":
-				mimeType = "video/x-msvideo"
-			case ".mkv":
-				mimeType = "video/x-matroska"
-			case ".webm":
-				mimeType = "video/webm"
-			case ".wmv":
-				mimeType = "video/x-ms-wmv"
-			case ".flv":
-				mimeType = "video/x-flv"
-			default:
-				mimeType = "video/mp4" // default fallback
-			}
-		}
+	// Upload: raw request body as the original for a client-chosen id
+	e.PUT("/videos/:id/original", server.putOriginal, authn.Require(auth.ScopeUpload))
 
-		m := meta.Metadata{
-			ID:               vid,
-			OriginalFilename: fh.Filename,
-			MIME:             mimeType,
-			SizeBytes:        n,
-			ChecksumSHA256:   "",
-			Status:           "queued",
-			StorageBase:      cfg.StorageDir,
-			Variants:         []meta.Variant{},
-		}
-		if err := store.Create(m); err != nil {
-			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot write metadata"})
-		}
+	// Import: fetch an original from an allowlisted URL or local path
+	e.POST("/videos/import", server.importVideo, authn.Require(auth.ScopeUpload))
 
-		// Start background processing
-		go proc.ProcessVideo(vid)
+	// Full-text search over filenames, titles, descriptions, tags and
+	// subtitle text. ?q= (a trailing * matches prefixes), ?limit= (default
+	// 20, at most 100) and ?offset= page through the ranked results.
+	e.GET("/search", server.searchVideos, authn.Require(auth.ScopeRead))
 
-		return c.JSON(http.StatusOK, uploadResponse{ID: vid})
-	})
+	e.GET("/videos/:id", server.getVideo, authn.Require(auth.ScopeRead))
 
-	e.GET("/videos/:id", func(c echo.Context) error {
-		vid := c.Param("id")
-		m, err := store.Get(vid)
-		if err != nil {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
-		}
+	// Edit title, description, tags, visibility and custom fields. Fields
+	// left out of the body are kept; a null custom value removes the key.
+	e.PATCH("/videos/:id", server.editVideo, authn.Require(auth.ScopeUpload))
 
-		return c.JSON(http.StatusOK, m)
-	})
+	// Delete a video and every file belonging to it
+	e.DELETE("/videos/:id", server.deleteVideo, authn.Require(auth.ScopeDelete))
 
-	e.GET("/videos/:id/master.m3u8", func(c echo.Context) error {
-		vid := c.Param("id")
-		p := filepath.Join(cfg.StorageDir, "outputs", vid, "master.m3u8")
-		if _, err := os.Stat(p); err != nil {
-			return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
-		}
+	// Playback URLs for a video, signed when URL_SIGNING_SECRET is set
+	e.GET("/videos/:id/playback", server.playbackURLs, authn.Require(auth.ScopeRead))
 
-		return c.File(p)
-	})
+	// Caption upload: SRT or WebVTT, one track per language
+	e.POST("/videos/:id/subtitles", server.uploadSubtitles, authn.Require(auth.ScopeUpload))
 
-	// Serve thumbnails
-	e.Static("/thumbnails", filepath.Join(cfg.StorageDir, "thumbnails"))
+	e.GET("/videos/:id/master.m3u8", server.masterPlaylist, playback...)
 
-	// Serve static HLS segments under /streams/:id/
-	e.Static("/streams", filepath.Join(cfg.StorageDir, "outputs"))
+	// DASH manifest. Redirect rather than serve it here so the segment URLs
+	// in the MPD resolve against /streams/<id>/dash/.
+	e.GET("/videos/:id/manifest.mpd", server.dashManifest, playback...)
 
-	e.Logger.Fatal(e.Start(":" + cfg.Port))
+	// Progressive MP4 download (MP4_DOWNLOAD). ServeContent handles Range,
+	// If-Range, If-None-Match and If-Modified-Since.
+	e.Match([]string{http.MethodGet, http.MethodHead}, "/videos/:id/download/:height", server.download, playback...)
+
+	// A frame at any position: ?t=seconds&w=width&format=jpeg|webp|png.
+	// Frames are cached on disk (FRAME_CACHE_MB) and identical concurrent
+	// requests share one ffmpeg run; at most FRAME_WORKERS run at once. With
+	// signing on, a signature over /videos/:id/frame (handed out by
+	// /playback) grants every frame of that video: t, w and format aren't
+	// covered.
+	e.GET("/videos/:id/frame", server.extractFrame, playback...)
+
+	// Thumbnails and poster of a video, signed when URL_SIGNING_SECRET is set
+	e.GET("/videos/:id/thumbnails", server.thumbnailURLs, authn.Require(auth.ScopeRead))
+
+	// AES-128 key delivery (HLS_ENCRYPTION). Keys are only released to
+	// requests carrying a URL signature, API credentials that can read the
+	// video, or HLS_KEY_TOKEN when the API is open.
+	e.GET("/videos/:id/keys/:n", server.key)
+
+	// Serve thumbnails. With signing, the sprite sheets referenced by a
+	// WebVTT thumbnail track get signatures like playlist URIs.
+	e.Group("/thumbnails", playback...).GET("/*", server.thumbnailFile)
+
+	// Serve HLS/DASH outputs under /streams/:id/. With signing, playlists
+	// are rewritten so every URI in them carries its own signature.
+	e.Group("/streams", playback...).GET("/*", server.streamFile)
+
+	// Collections: ordered groups of videos, e.g. the lessons of a course
+	e.POST("/collections", server.createCollection, authn.Require(auth.ScopeUpload))
+
+	// The caller's collections plus the public ones of the tenant
+	e.GET("/collections", server.listCollections, authn.Require(auth.ScopeRead))
+
+	e.GET("/collections/:id", server.showCollection, authn.Require(auth.ScopeRead))
+
+	// Title, description, visibility, cover, and video_ids to reorder or
+	// replace the membership
+	e.PATCH("/collections/:id", server.editCollection, authn.Require(auth.ScopeUpload))
+
+	e.DELETE("/collections/:id", server.deleteCollection, authn.Require(auth.ScopeDelete))
+
+	// Add a video: {"video_id": "...", "position": n}; without a position
+	// it is appended
+	e.POST("/collections/:id/videos", server.addCollectionVideo, authn.Require(auth.ScopeUpload))
+
+	e.DELETE("/collections/:id/videos/:vid", server.removeCollectionVideo, authn.Require(auth.ScopeUpload))
+
+	// Generated HLS playlists playing the ready videos back to back. With
+	// URL signing the signature is the authorization, as for videos, and
+	// there is no caller to check members against: /owner/ playlists,
+	// issued to the collection's owner only, add the owner's private videos
+	// to the shared ones.
+	collectionPlayback := requireSigned
+	if server.signer == nil {
+		collectionPlayback = []echo.MiddlewareFunc{authn.Require(auth.ScopeRead)}
+	}
+	for prefix, ownerScope := range map[string]bool{"/collections/:id": false, "/collections/:id/owner": true} {
+		e.GET(prefix+"/master.m3u8", server.collectionMaster(ownerScope), collectionPlayback...)
+		e.GET(prefix+"/hls/:rendition", server.collectionRendition(ownerScope), collectionPlayback...)
+	}
+}
+
+func (server *Server) health(c echo.Context) error {
+	return c.String(http.StatusOK, "OK")
+}
+
+// logLadder reports renditions whose encoder this ffmpeg lacks; they are
+// skipped when encoding. A bad LADDER has already stopped startup.
+func logLadder(cfg config.Config) {
+	ladder, err := transcoder.LadderFor(cfg)
+	if err != nil {
+		return
+	}
+	if os.Getenv("LADDER_MODE") != "" {
+		log.Printf("LADDER_MODE is no longer read; add mode=%s to LADDER instead", os.Getenv("LADDER_MODE"))
+	}
+	available, err := transcoder.AvailableEncoders(context.Background(), exec.NewCommandRunner(), cfg.FFmpegPath)
+	if err != nil {
+		log.Printf("Cannot detect ffmpeg encoders: %v", err)
+		return
+	}
+	_, skipped := ladder.Usable(available)
+	for _, res := range skipped {
+		log.Printf("Skipping %s rendition: encoder %s not available", res.Label(), res.Encoder)
+	}
+}
+
+// logEncryption reports HLS_ENCRYPTION settings that won't do what was asked.
+// Keys go out on a signed key URL or API credentials too, so only an open
+// API without a key token releases none.
+func logEncryption(cfg config.Config, signer *signing.Signer, authenticator *auth.Authenticator) {
+	switch cfg.Encryption {
+	case "none", "":
+		return
+	case "aes-128":
+	default:
+		log.Printf("Unsupported HLS_ENCRYPTION %q (only aes-128 is supported); segments will not be encrypted", cfg.Encryption)
+		return
+	}
+	if signer == nil && authenticator == nil && cfg.KeyToken == "" {
+		log.Printf("HLS_ENCRYPTION is on but HLS_KEY_TOKEN is empty; no key will be released")
+	}
+}
+
+// ownerOf is the authenticated caller recorded as a new video's owner; empty
+// when authentication is off.
+func ownerOf(c echo.Context) string {
+	if principal := appmiddleware.CurrentPrincipal(c); principal != nil {
+		return principal.ID
+	}
+	return ""
+}
+
+// tenantOf is the caller's tenant; "" (the default tenant) when
+// authentication is off or the credentials name none.
+func tenantOf(c echo.Context) string {
+	if principal := appmiddleware.CurrentPrincipal(c); principal != nil {
+		return principal.Tenant
+	}
+	return ""
+}
+
+// tenantStore confines store to the caller's tenant. Without authentication,
+// for signed requests and for admins outside any tenant it sees everything.
+func tenantStore(c echo.Context, store meta.Store) meta.Store {
+	principal := appmiddleware.CurrentPrincipal(c)
+	if principal == nil || principal.Global() {
+		return store
+	}
+	return meta.NewScopedStore(store, principal.Tenant)
+}
+
+// owns reports whether the caller owns m (or is an admin). Everything is
+// owned when authentication is off.
+func owns(c echo.Context, m meta.Metadata) bool {
+	principal := appmiddleware.CurrentPrincipal(c)
+	return principal == nil || principal.Owns(m.Owner)
+}
+
+// visible reports whether the caller may see m: its own videos plus
+// unlisted and public ones of the tenant.
+func visible(c echo.Context, m meta.Metadata) bool {
+	return owns(c, m) || m.Shared()
+}
+
+// visibleOnly answers 404 for videos the caller may not see. The video id is
+// the :id parameter or, for /streams and /thumbnails, the first path segment.
+func visibleOnly(store meta.Store) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			vid := c.Param("id")
+			if vid == "" {
+				vid, _, _ = strings.Cut(strings.TrimPrefix(c.Param("*"), "/"), "/")
+			}
+			if m, err := tenantStore(c, store).Get(vid); err != nil || !visible(c, m) {
+				return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+			}
+
+			return next(c)
+		}
+	}
+}
+
+// decodeJSON decodes a small JSON request body, rejecting unknown fields.
+func decodeJSON(c echo.Context, v any) error {
+	decoder := json.NewDecoder(io.LimitReader(c.Request().Body, maxDetailsBytes))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
 }
@@ -0,0 +1,502 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"upload/internal/config"
+	"upload/internal/exec"
+	"upload/internal/fsutil"
+	"upload/internal/httpapi"
+	"upload/internal/id"
+	"upload/internal/importer"
+	"upload/internal/ingest"
+	"upload/internal/meta"
+	appmiddleware "upload/internal/middleware"
+	"upload/internal/quota"
+	"upload/internal/subtitle"
+	"upload/internal/transcoder"
+)
+
+func (server *Server) usage(c echo.Context) error {
+	tenant := tenantOf(c)
+	if principal := appmiddleware.CurrentPrincipal(c); principal == nil || principal.Global() {
+		tenant = c.QueryParam("tenant")
+		if tenant != "" && !fsutil.ValidTenant(tenant) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid tenant"})
+		}
+	}
+	usage, err := server.quotas.Usage(tenant)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot compute usage"})
+	}
+	return c.JSON(http.StatusOK, usage)
+}
+
+func (server *Server) tenants(c echo.Context) error {
+	if principal := appmiddleware.CurrentPrincipal(c); principal != nil && !principal.Global() {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "forbidden"})
+	}
+	tenants, err := server.quotas.Tenants()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot list tenants"})
+	}
+	usages := make([]quota.Usage, 0, len(tenants))
+	for _, tenant := range tenants {
+		usage, err := server.quotas.Usage(tenant)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot compute usage"})
+		}
+		usages = append(usages, usage)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"tenants": usages})
+}
+
+func (server *Server) listVideos(c echo.Context) error {
+	videos, err := tenantStore(c, server.store).List()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to list videos"})
+	}
+	filter := videoFilter(c)
+	listed := videos[:0]
+	for _, v := range videos {
+		if (owns(c, v) || v.IsPublic()) && filter.Match(v) {
+			listed = append(listed, v)
+		}
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{"videos": listed})
+}
+
+func (server *Server) uploadVideo(c echo.Context) error {
+	mr, err := c.Request().MultipartReader()
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "multipart body is required"})
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "malformed multipart body"})
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		m, err := saveOriginal(server.config, server.store, server.quotas, id.New(), ownerOf(c), tenantOf(c), part.FileName(), part.Header.Get("Content-Type"), part, -1, c.Request().ContentLength)
+		part.Close()
+		if err != nil {
+			return uploadError(c, server.config, err)
+		}
+
+		// Start background processing
+		server.enqueue(m.ID)
+
+		return c.JSON(http.StatusOK, uploadResponse{ID: m.ID})
+	}
+
+	return c.JSON(http.StatusBadRequest, map[string]string{"error": "file is required"})
+}
+
+func (server *Server) putOriginal(c echo.Context) error {
+	vid := c.Param("id")
+	if !id.Valid(vid) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid id"})
+	}
+	req := c.Request()
+	if req.ContentLength < 0 {
+		return c.JSON(http.StatusLengthRequired, map[string]string{"error": "Content-Length is required"})
+	}
+	if req.ContentLength > maxUploadBytes(server.config) {
+		return uploadError(c, server.config, ingest.ErrTooLarge)
+	}
+	if _, err := tenantStore(c, server.store).Get(vid); err == nil {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "original already uploaded"})
+	}
+
+	contentType := req.Header.Get("Content-Type")
+	filename := c.QueryParam("filename")
+	if filename == "" {
+		if _, params, err := mime.ParseMediaType(req.Header.Get("Content-Disposition")); err == nil {
+			filename = params["filename"]
+		}
+	}
+
+	m, err := saveOriginal(server.config, server.store, server.quotas, vid, ownerOf(c), tenantOf(c), filename, contentType, io.LimitReader(req.Body, req.ContentLength), req.ContentLength, req.ContentLength)
+	if err != nil {
+		return uploadError(c, server.config, err)
+	}
+
+	// Start background processing
+	server.enqueue(m.ID)
+
+	return c.JSON(http.StatusOK, uploadResponse{ID: m.ID})
+}
+
+func (server *Server) importVideo(c echo.Context) error {
+	var req importer.Request
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+	req.Owner = ownerOf(c)
+	req.Tenant = tenantOf(c)
+	if err := server.quotas.CheckEncoding(req.Tenant); err != nil {
+		return uploadError(c, server.config, err)
+	}
+	// held until the fetch ends, like saveOriginal does for uploads
+	maxSize := int64(server.config.ImportMaxMB) * 1024 * 1024
+	if maxSize <= 0 {
+		maxSize = -1
+	}
+	reservation, err := server.quotas.Reserve(req.Tenant, maxSize)
+	if err != nil {
+		return uploadError(c, server.config, err)
+	}
+	req.Quota = reservation.Limit()
+	req.Release = reservation.Release
+
+	m, err := server.importer.Start(req)
+	switch {
+	case errors.Is(err, importer.ErrSourceNotAllowed):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, importer.ErrInvalidSource):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot start import"})
+	}
+
+	return c.JSON(http.StatusAccepted, httpapi.UploadResponse{ID: m.ID, Status: m.Status})
+}
+
+func (server *Server) searchVideos(c echo.Context) error {
+	q := strings.TrimSpace(c.QueryParam("q"))
+	if q == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "q is required"})
+	}
+	limit, offset := 20, 0
+	if v := c.QueryParam("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "limit must be between 1 and 100"})
+		}
+		limit = n
+	}
+	if v := c.QueryParam("offset"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "offset must not be negative"})
+		}
+		offset = n
+	}
+
+	// same rules as GET /videos: own videos plus public ones of the tenant
+	scoped := tenantStore(c, server.store)
+	videos := map[string]meta.Metadata{}
+	server.store.Refresh()
+	hits, total := server.index.Search(q, func(vid string) bool {
+		m, err := scoped.Get(vid)
+		if err != nil || !(owns(c, m) || m.IsPublic()) {
+			return false
+		}
+		videos[vid] = m
+		return true
+	}, offset, limit)
+
+	resp := httpapi.SearchResponse{Query: q, Total: total, Offset: offset, Limit: limit, Results: []httpapi.SearchResult{}}
+	for _, hit := range hits {
+		resp.Results = append(resp.Results, httpapi.SearchResult{
+			ID:         hit.ID,
+			Score:      hit.Score,
+			Highlights: hit.Highlights,
+			Video:      videos[hit.ID],
+		})
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (server *Server) getVideo(c echo.Context) error {
+	vid := c.Param("id")
+	m, err := tenantStore(c, server.store).Get(vid)
+	if err != nil || !visible(c, m) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+
+	return c.JSON(http.StatusOK, m)
+}
+
+func (server *Server) editVideo(c echo.Context) error {
+	vid := c.Param("id")
+	if !id.Valid(vid) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	scoped := tenantStore(c, server.store)
+	m, err := scoped.Get(vid)
+	if err != nil || !visible(c, m) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if !owns(c, m) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "only the owner can edit this video"})
+	}
+
+	var edit meta.Edit
+	if err := decodeJSON(c, &edit); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid request body"})
+	}
+
+	m, err = scoped.Modify(vid, func(m *meta.Metadata) error {
+		return edit.Apply(&m.Details)
+	})
+	switch {
+	case errors.Is(err, meta.ErrInvalidDetails):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
+	case err != nil:
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot update metadata"})
+	}
+
+	return c.JSON(http.StatusOK, m)
+}
+
+func (server *Server) deleteVideo(c echo.Context) error {
+	vid := c.Param("id")
+	if !id.Valid(vid) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	m, err := tenantStore(c, server.store).Get(vid)
+	if err != nil || !owns(c, m) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if m.Status == "processing" || m.Status == "importing" {
+		return c.JSON(http.StatusConflict, map[string]string{"error": "video is " + m.Status + "; cancel it first"})
+	}
+
+	root := fsutil.TenantRoot(server.config.StorageDir, m.Tenant)
+	for _, dir := range []string{
+		fsutil.OriginalsDir(root, vid),
+		fsutil.OutputsDir(root, vid),
+		fsutil.ThumbnailsDir(root, vid),
+		fsutil.KeysDir(root, vid),
+	} {
+		if err := os.RemoveAll(dir); err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot delete files"})
+		}
+	}
+	if err := server.store.Delete(vid); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot delete metadata"})
+	}
+	if err := server.collections.RemoveVideo(vid); err != nil {
+		log.Printf("Failed to remove %s from collections: %v", vid, err)
+	}
+	server.frames.Forget(vid + "_")
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (server *Server) uploadSubtitles(c echo.Context) error {
+	vid := c.Param("id")
+	if !id.Valid(vid) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+	if m, err := tenantStore(c, server.store).Get(vid); err != nil || !owns(c, m) {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not found"})
+	}
+
+	language := c.FormValue("language")
+	if !subtitle.ValidLanguage(language) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "language must be a language tag such as en or pt-BR"})
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "file is required"})
+	}
+	if fileHeader.Size > maxSubtitleBytes {
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{"error": "subtitle file too large, max size is 10 MB"})
+	}
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(fileHeader.Filename)), ".")
+	if format != "srt" && format != "vtt" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "subtitle must be an .srt or .vtt file"})
+	}
+
+	f, err := fileHeader.Open()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot read file"})
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxSubtitleBytes))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot read file"})
+	}
+	cues, err := subtitle.Parse(data, format)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("invalid subtitle file: %v", err)})
+	}
+
+	isDefault, _ := strconv.ParseBool(c.FormValue("default"))
+	forced, _ := strconv.ParseBool(c.FormValue("forced"))
+	codec := "subrip"
+	if format == "vtt" {
+		codec = "webvtt"
+	}
+	track := meta.SubtitleTrack{
+		Codec:    codec,
+		Language: language,
+		Name:     c.FormValue("name"),
+		Default:  isDefault,
+		Forced:   forced,
+	}
+	m, err := transcoder.NewTranscoder(server.config, exec.NewCommandRunner(), server.store).AddSubtitle(vid, track, cues)
+	if err != nil {
+		log.Printf("Failed to add subtitles to %s: %v", vid, err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot save subtitles"})
+	}
+
+	// not ready yet: packaged when transcoding finishes
+	if m.Status != "ready" {
+		return c.JSON(http.StatusAccepted, m)
+	}
+	return c.JSON(http.StatusCreated, m)
+}
+
+// videoFilter reads the GET /videos search parameters.
+func videoFilter(c echo.Context) meta.Filter {
+	params := c.QueryParams()
+	filter := meta.Filter{
+		Query:      strings.TrimSpace(params.Get("q")),
+		Status:     params.Get("status"),
+		Visibility: params.Get("visibility"),
+		Custom:     map[string]string{},
+	}
+	for _, tags := range params["tag"] {
+		for _, tag := range strings.Split(tags, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				filter.Tags = append(filter.Tags, tag)
+			}
+		}
+	}
+	for key, values := range params {
+		if name, ok := strings.CutPrefix(key, "custom."); ok && name != "" {
+			filter.Custom[name] = values[0]
+		}
+	}
+
+	return filter
+}
+
+func maxUploadBytes(cfg config.Config) int64 {
+	return int64(cfg.MaxUploadMB) * 1024 * 1024
+}
+
+// saveOriginal streams r into the originals layout of tenant for vid and
+// records the queued metadata. Nothing is written to the store if the stream
+// fails, is not exactly size bytes (size < 0 accepts any length) or the
+// tenant's quotas are used up. maxSize bounds the stream (-1 if unknown) and
+// is what gets reserved of the storage quota while writing. The originals
+// directory is claimed before writing, so concurrent uploads for one id fail
+// with fs.ErrExist.
+func saveOriginal(cfg config.Config, store meta.Store, quotas *quota.Manager, vid, owner, tenant, filename, contentType string, r io.Reader, size, maxSize int64) (meta.Metadata, error) {
+	if err := quotas.CheckEncoding(tenant); err != nil {
+		return meta.Metadata{}, err
+	}
+	limit := maxUploadBytes(cfg)
+	if limit > 0 && (maxSize < 0 || maxSize > limit) {
+		maxSize = limit
+	}
+	reservation, err := quotas.Reserve(tenant, maxSize)
+	if err != nil {
+		return meta.Metadata{}, err
+	}
+	defer reservation.Release()
+	if size >= 0 && reservation.Limit() >= 0 && size > reservation.Limit() {
+		return meta.Metadata{}, quota.ErrStorageExceeded
+	}
+	quotaBound := reservation.Limit() >= 0 && (limit <= 0 || reservation.Limit() < limit)
+	if quotaBound {
+		limit = reservation.Limit()
+	}
+
+	filename = filepath.Base(filename)
+	if filename == "." || filename == string(filepath.Separator) {
+		filename = "original" + ingest.ExtensionFor(ingest.DetectMIME(contentType, ""))
+	}
+	root := fsutil.TenantRoot(cfg.StorageDir, tenant)
+	dir := fsutil.OriginalsDir(root, vid)
+	if err := os.MkdirAll(filepath.Dir(dir), 0o755); err != nil {
+		return meta.Metadata{}, err
+	}
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		return meta.Metadata{}, err
+	}
+	dstPath := fsutil.OriginalPath(root, vid, filename)
+	res, err := ingest.Save(dstPath, r, limit)
+	if err != nil {
+		os.RemoveAll(dir)
+		if errors.Is(err, ingest.ErrTooLarge) && quotaBound {
+			return meta.Metadata{}, quota.ErrStorageExceeded
+		}
+		return meta.Metadata{}, err
+	}
+	if size >= 0 && res.SizeBytes != size {
+		os.RemoveAll(dir)
+		return meta.Metadata{}, errShortBody
+	}
+
+	m := meta.Metadata{
+		ID:               vid,
+		OriginalFilename: filename,
+		MIME:             ingest.DetectMIME(contentType, filepath.Ext(filename)),
+		SizeBytes:        res.SizeBytes,
+		ChecksumSHA256:   res.ChecksumSHA256,
+		Status:           "queued",
+		Tenant:           tenant,
+		Owner:            owner,
+		StorageBase:      root,
+		Variants:         []meta.Variant{},
+	}
+	if err := store.Create(m); err != nil {
+		os.RemoveAll(dir)
+		if errors.Is(err, fs.ErrExist) {
+			return meta.Metadata{}, err
+		}
+		return meta.Metadata{}, errMetadata
+	}
+
+	return m, nil
+}
+
+var (
+	errMetadata  = errors.New("cannot write metadata")
+	errShortBody = errors.New("body shorter than Content-Length")
+)
+
+func uploadError(c echo.Context, cfg config.Config, err error) error {
+	switch {
+	case errors.Is(err, ingest.ErrTooLarge):
+		return c.JSON(http.StatusRequestEntityTooLarge, map[string]string{
+			"error": fmt.Sprintf("file too large, max size is %d MB", cfg.MaxUploadMB),
+		})
+	case errors.Is(err, quota.ErrStorageExceeded), errors.Is(err, quota.ErrEncodingExceeded):
+		return c.JSON(http.StatusForbidden, map[string]string{"error": err.Error()})
+	case errors.Is(err, errMetadata):
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot write metadata"})
+	case errors.Is(err, fs.ErrExist):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "original already uploaded"})
+	case errors.Is(err, errShortBody), errors.Is(err, io.ErrUnexpectedEOF):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": errShortBody.Error()})
+	}
+
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "cannot write file"})
+}